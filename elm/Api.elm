@@ -3,6 +3,7 @@ module Api exposing
     , EventMessage
     , EventResponseMessage
     , ServerAction(..)
+    , Snapshot
     , decodeMessage
     , encodeToMessage
     )
@@ -15,13 +16,25 @@ import Material exposing (Material, Resource)
 
 
 type Action
-    = Welcome String
+    = Welcome String String
     | GameStateChanged StageType
     | SetClock Int
     | PlayerInfoUpdated (List PlayerInfo)
+    | SiteAssigned Site
     | TradeCompleted (Material Int)
     | Event EventMessage
     | GameOver String
+    | StateSnapshot Snapshot
+    | InventoryUpdated (Material Int)
+    | HealthUpdated Int
+    | PlayerDied String
+    | TradeOffered Int String
+    | TradeClosed Int String
+    | ServerError String String
+    | RepairUpdated Site Int
+    | Upkeep (List UpkeepInfo)
+    | DefenseSummary Site String
+    | Unrecognized String
 
 
 type alias EventMessage =
@@ -35,6 +48,16 @@ type alias EventMessage =
     }
 
 
+{-| The parts of the server's state snapshot we keep track of. The server
+owns these, so the snapshot overrides whatever we had.
+-}
+type alias Snapshot =
+    { time : Int
+    , health : Int
+    , inventory : Material Int
+    }
+
+
 decodeMessage : String -> Result String Action
 decodeMessage =
     D.decodeString action
@@ -58,20 +81,30 @@ actionHelp a =
                                 "ready" ->
                                     D.succeed WaitStageType
 
+                                "waiting" ->
+                                    D.succeed WaitStageType
+
                                 "site_selection" ->
                                     D.succeed SiteSelectionStageType
 
                                 "site_visit" ->
                                     D.succeed SiteVisitStageType
 
+                                "upkeep" ->
+                                    D.succeed UpkeepStageType
+
+                                "game_over" ->
+                                    D.succeed GameOverStageType
+
                                 _ ->
                                     D.fail "Unrecognized stage name"
                         )
                 )
 
         "welcome" ->
-            D.map Welcome <|
-                D.field "game" D.string
+            D.map2 Welcome
+                (D.field "game" D.string)
+                (D.field "session" D.string)
 
         "set_clock" ->
             D.map SetClock
@@ -88,6 +121,11 @@ actionHelp a =
                     )
                 )
 
+        "site_selected" ->
+            -- sent when the server picks a site for us
+            D.map SiteAssigned <|
+                D.field "site" site
+
         "trade_completed" ->
             -- [note] material field is whatever str we passed in to `trade` msg
             D.map TradeCompleted <|
@@ -119,8 +157,65 @@ actionHelp a =
             D.map GameOver <|
                 D.field "winner" D.string
 
+        "state_snapshot" ->
+            D.map StateSnapshot <|
+                D.map3 Snapshot
+                    (D.field "time" D.int)
+                    (D.field "health" D.int)
+                    (D.field "inventory" (material D.int))
+
+        "inventory_updated" ->
+            D.map InventoryUpdated <|
+                D.field "inventory" (material D.int)
+
+        "health_updated" ->
+            D.map HealthUpdated <|
+                D.field "health" D.int
+
+        "player_died" ->
+            D.map PlayerDied <|
+                D.field "name" D.string
+
+        "trade_offered" ->
+            D.map2 TradeOffered
+                (D.field "trade_id" D.int)
+                (D.field "from" D.string)
+
+        "trade_closed" ->
+            D.map2 TradeClosed
+                (D.field "trade_id" D.int)
+                (D.field "reason" D.string)
+
+        "error" ->
+            D.map2 ServerError
+                (D.field "code" D.string)
+                (D.field "detail" D.string)
+
+        "repair_updated" ->
+            D.map2 RepairUpdated
+                (D.field "site" site)
+                (D.field "repair" D.int)
+
+        "upkeep" ->
+            D.map Upkeep
+                (D.field "players"
+                    (D.list
+                        (D.map3 UpkeepInfo
+                            (D.field "name" D.string)
+                            (D.field "food" D.int)
+                            (D.field "short" D.int)
+                        )
+                    )
+                )
+
+        "defense_summary" ->
+            D.map2 DefenseSummary
+                (D.field "site" site)
+                (D.field "outcome" D.string)
+
         _ ->
-            D.fail ("Received unrecognized action from server: " ++ a)
+            -- newer servers can send things we don't know about yet
+            D.succeed (Unrecognized a)
 
 
 okButton : D.Decoder (Maybe String)
@@ -182,6 +277,20 @@ resource =
             )
 
 
+site : D.Decoder Site
+site =
+    D.string
+        |> D.andThen
+            (\s ->
+                case List.filter (\x -> siteToString x == s) allSites of
+                    x :: _ ->
+                        D.succeed x
+
+                    [] ->
+                        D.fail "Unrecognized site name"
+            )
+
+
 material : D.Decoder a -> D.Decoder (Material a)
 material a =
     D.map4 Material
@@ -4,6 +4,7 @@ module BaseType exposing
     , Site(..)
     , StageType(..)
     , Uber(..)
+    , UpkeepInfo
     , add
     , allSites
     , siteToString
@@ -19,6 +20,15 @@ type alias PlayerInfo =
     }
 
 
+{-| How much food a player has for the day, and how much they are short.
+-}
+type alias UpkeepInfo =
+    { name : String
+    , food : Int
+    , short : Int
+    }
+
+
 type Site
     = Forest
     | Farm
@@ -40,6 +50,7 @@ type StageType
     = WaitStageType
     | SiteSelectionStageType
     | SiteVisitStageType
+    | UpkeepStageType
     | GameOverStageType
 
 
@@ -6,6 +6,7 @@ module Model exposing
     , SiteSelectionModel
     , SiteVisitModel
     , Stage(..)
+    , UpkeepModel
     , WaitModel
     , getStageType
     , initAppModel
@@ -57,6 +58,7 @@ type alias JoinGameModel =
 
 type alias GameModel =
     { gameName : String
+    , session : String
     , playerName : String
     , stage : Stage
     , health : Float
@@ -74,6 +76,7 @@ type Stage
       WaitStage WaitModel
     | SiteSelectionStage SiteSelectionModel
     | SiteVisitStage SiteVisitModel
+    | UpkeepStage UpkeepModel
     | GameOverStage
 
 
@@ -95,6 +98,11 @@ type alias SiteVisitModel =
     }
 
 
+type alias UpkeepModel =
+    { players : List UpkeepInfo
+    }
+
+
 type alias Event =
     Extra Api.EventMessage
 
@@ -134,9 +142,10 @@ maxAntihunger =
     2
 
 
-initGameModel : String -> GameModel
-initGameModel name =
+initGameModel : String -> String -> GameModel
+initGameModel name session =
     { gameName = name
+    , session = session
     , playerName = "Anonymous"
     , stage = WaitStage initWaitModel
     , health = toFloat maxHealth
@@ -174,10 +183,13 @@ getStageType stage =
             WaitStageType
 
         SiteSelectionStage _ ->
-            SiteVisitStageType
+            SiteSelectionStageType
 
         SiteVisitStage _ ->
             SiteVisitStageType
 
+        UpkeepStage _ ->
+            UpkeepStageType
+
         GameOverStage ->
             GameOverStageType
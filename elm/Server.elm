@@ -9,24 +9,38 @@ type alias SendToServer =
     Api.ServerAction -> Cmd Msg
 
 
-wsURL : String -> String -> String
-wsURL hostname gameName =
-    "ws://" ++ hostname ++ "/join?game=" ++ gameName
+{-| The session is the token from the welcome message, or empty before we
+have one. Once it's in the URL, reconnecting resumes our place in the game.
+-}
+wsURL : String -> String -> String -> String
+wsURL hostname gameName session =
+    "ws://"
+        ++ hostname
+        ++ "/join?game="
+        ++ gameName
+        ++ (if session == "" then
+                ""
+
+            else
+                "&session=" ++ session
+           )
 
 
 send :
     { m | hostname : String }
     -> String
+    -> String
     -> Api.ServerAction
     -> Cmd Msg
-send { hostname } gameName =
-    WebSocket.send (wsURL hostname gameName) << Api.encodeToMessage
+send { hostname } gameName session =
+    WebSocket.send (wsURL hostname gameName session) << Api.encodeToMessage
 
 
 listen :
     { m | hostname : String }
     -> String
+    -> String
     -> (Result String Api.Action -> Msg)
     -> Sub Msg
-listen { hostname } gameName handler =
-    WebSocket.listen (wsURL hostname gameName) (handler << Api.decodeMessage)
+listen { hostname } gameName session handler =
+    WebSocket.listen (wsURL hostname gameName session) (handler << Api.decodeMessage)
@@ -25,6 +25,7 @@ subscriptions model =
                     Just gameName ->
                         [ Server.listen model
                             gameName
+                            ""
                             (AppMsg << ServerMsgReceived)
                         ]
 
@@ -34,6 +35,7 @@ subscriptions model =
             GameScreen m ->
                 [ Server.listen model
                     m.gameName
+                    m.session
                     (AppMsg << ServerMsgReceived)
                 , Shake.shake
                     (AppMsg
@@ -58,7 +60,7 @@ type alias Upd model =
 
 
 type alias Ctx msg =
-    { toServer : String -> Server.SendToServer
+    { toServer : String -> String -> Server.SendToServer
     , toMsg : msg -> Msg
     }
 
@@ -104,7 +106,11 @@ updateApp ctx msg model =
                     model |> handleAction action ctx
 
                 Err e ->
-                    Debug.crash <| "Error in ServerMsgReceived: " ++ e
+                    let
+                        _ =
+                            Debug.log "Error in ServerMsgReceived" e
+                    in
+                    model ! []
 
 
 updateWelcome : Ctx JoinGameMsg -> JoinGameMsg -> Upd JoinGameModel
@@ -120,7 +126,7 @@ updateWelcome { toServer } msg model =
                        or does the server add us to the game automatically
                        upon ws connection?
                     -}
-                    toServer gameName (Api.JoinGame gameName)
+                    toServer gameName "" (Api.JoinGame gameName)
                   ]
 
         GameNameInputChange str ->
@@ -129,11 +135,11 @@ updateWelcome { toServer } msg model =
 
 mkGameCtx :
     Ctx outermsg
-    -> { a | gameName : String }
+    -> { a | gameName : String, session : String }
     -> (innermsg -> outermsg)
     -> GameCtx innermsg
-mkGameCtx { toServer, toMsg } { gameName } msgWrap =
-    { toGameServer = toServer gameName
+mkGameCtx { toServer, toMsg } { gameName, session } msgWrap =
+    { toGameServer = toServer gameName session
     , toMsg = toMsg << msgWrap
     }
 
@@ -367,8 +373,19 @@ updateSiteVisit { toGameServer } msg ( siteVisitModel, gameModel ) =
 handleAction : Api.Action -> Ctx AppMsg -> Upd AppModel
 handleAction action ctx model =
     case action of
-        Api.Welcome name ->
-            GameScreen (initGameModel name) ! []
+        Api.Welcome name session ->
+            case model of
+                GameScreen m ->
+                    if m.gameName == name then
+                        -- the server just caught us up after we joined
+                        -- again or resumed, so keep what we have
+                        GameScreen { m | session = session } ! []
+
+                    else
+                        GameScreen (initGameModel name session) ! []
+
+                _ ->
+                    GameScreen (initGameModel name session) ! []
 
         Api.GameStateChanged stage ->
             tryUpdate game
@@ -397,43 +414,140 @@ handleAction action ctx model =
                 (\m -> { m | playerInfo = info } ! [])
                 model
 
+        Api.SiteAssigned site ->
+            tryUpdate (game |> goIn siteSelection)
+                (\m -> { m | siteSelected = Just site } ! [])
+                model
+
         Api.TradeCompleted mat ->
             tryUpdate game
                 (\m -> { m | basket = mat } ! [])
                 model
 
         Api.Event e ->
-            -- [toclean] clean up into pipelines
-            model
-                |> tryUpdate game
-                    (\m ->
-                        updateHealth (toFloat e.healthModifier)
-                            (mkGameCtx ctx m GameMsg)
-                            m
-                    )
-                |> (\( model2, cmd ) ->
-                        model2
-                            |> tryUpdate
-                                (game |> goIn siteVisit)
-                                (\m ->
-                                    { m
-                                        | event =
-                                            Just
-                                                { messageId = e.messageId
-                                                , title = e.title
-                                                , description = e.description
-                                                , okButton = e.okButton
-                                                , spendButton = e.spendButton
-                                                , actionButton = e.actionButton
-                                                , resourceAmountSelected = 0
-                                                , healthModifier = e.healthModifier
-                                                }
-                                    }
-                                        ! [ cmd ]
-                                )
-                   )
+            -- the server applies e.healthModifier itself, and tells us
+            -- with health_updated
+            tryUpdate (game |> goIn siteVisit)
+                (\m ->
+                    { m
+                        | event =
+                            Just
+                                { messageId = e.messageId
+                                , title = e.title
+                                , description = e.description
+                                , okButton = e.okButton
+                                , spendButton = e.spendButton
+                                , actionButton = e.actionButton
+                                , resourceAmountSelected = 0
+                                , healthModifier = e.healthModifier
+                                }
+                    }
+                        ! []
+                )
+                model
 
         Api.GameOver winner ->
+            tryUpdate game
+                (\m -> { m | stage = GameOverStage, timer = Nothing } ! [])
+                model
+
+        Api.StateSnapshot snapshot ->
+            tryUpdate game
+                (\m ->
+                    { m
+                        | health = toFloat snapshot.health
+                        , inventory = snapshot.inventory
+                        , basket = Material.empty
+                        , timer =
+                            if snapshot.time > 0 then
+                                Just <|
+                                    Timer.init
+                                        (toFloat snapshot.time * Time.millisecond)
+
+                            else
+                                Nothing
+                    }
+                        ! []
+                )
+                model
+
+        Api.InventoryUpdated inventory ->
+            -- the server owns the inventory, and has already taken
+            -- anything we spent or traded away
+            tryUpdate game
+                (\m ->
+                    { m
+                        | inventory = inventory
+                        , basket = Material.empty
+                    }
+                        ! []
+                )
+                model
+
+        Api.HealthUpdated health ->
+            -- the server decides when we die, so don't report it back
+            tryUpdate game
+                (\m -> { m | health = toFloat health } ! [])
+                model
+
+        Api.PlayerDied name ->
+            let
+                _ =
+                    Debug.log "Player died" name
+            in
+            model ! []
+
+        Api.TradeOffered id from ->
+            -- [todo] show offers, we only trade by bumping for now
+            let
+                _ =
+                    Debug.log "Trade offered" ( id, from )
+            in
+            model ! []
+
+        Api.TradeClosed id reason ->
+            -- anything we get back arrives as an inventory update
+            let
+                _ =
+                    Debug.log "Trade closed" ( id, reason )
+            in
+            model ! []
+
+        Api.ServerError code detail ->
+            -- the server put right anything we got wrong, e.g. by
+            -- resending our inventory
+            let
+                _ =
+                    Debug.log "Server rejected our message" ( code, detail )
+            in
+            model ! []
+
+        Api.RepairUpdated site repair ->
+            -- [todo] show how run down each site is
+            let
+                _ =
+                    Debug.log "Repair updated" ( site, repair )
+            in
+            model ! []
+
+        Api.Upkeep players ->
+            tryUpdate (game |> goIn upkeep)
+                (\m -> { m | players = players } ! [])
+                model
+
+        Api.DefenseSummary site outcome ->
+            -- [todo] show how the watchtower defended the site
+            let
+                _ =
+                    Debug.log "Attack defended" ( site, outcome )
+            in
+            model ! []
+
+        Api.Unrecognized name ->
+            let
+                _ =
+                    Debug.log "Ignoring unrecognized action" name
+            in
             model ! []
 
 
@@ -473,6 +587,16 @@ updateHealthWithAntihunger ctx model =
 
 changeStage : StageType -> GameCtx msg -> Upd GameModel
 changeStage stagetype ctx model =
+    if getStageType model.stage == stagetype then
+        -- catching up after joining again, we're already there
+        model ! []
+
+    else
+        changeStageHelp stagetype ctx model
+
+
+changeStageHelp : StageType -> GameCtx msg -> Upd GameModel
+changeStageHelp stagetype ctx model =
     let
         oldStage =
             model.stage
@@ -506,6 +630,12 @@ changeStage stagetype ctx model =
                         Nothing ->
                             Debug.crash "No site selected before transition"
 
+                ( _, UpkeepStageType ) ->
+                    ( UpkeepStage { players = [] }, model ! [] )
+
+                ( _, GameOverStageType ) ->
+                    ( GameOverStage, model ! [] )
+
                 _ ->
                     Debug.crash
                         ("Invalid stage transtion from "
@@ -614,6 +744,23 @@ siteVisit =
     { get = get, set = set }
 
 
+upkeep : EffLens UpkeepModel GameModel
+upkeep =
+    let
+        get model =
+            case model.stage of
+                UpkeepStage m ->
+                    Just m
+
+                _ ->
+                    Nothing
+
+        set ( m, cmd ) model =
+            Just ( { model | stage = UpkeepStage m }, cmd )
+    in
+    { get = get, set = set }
+
+
 tryUpdate :
     Lens submodel updatedSubmodel model (Eff model)
     -> (submodel -> updatedSubmodel)
@@ -73,6 +73,9 @@ gameView model =
                                 SiteVisitStage m ->
                                     Html.map SiteVisitMsg (siteVisitView model m)
 
+                                UpkeepStage m ->
+                                    upkeepView m
+
                                 GameOverStage ->
                                     gameOverView
                           ]
@@ -412,6 +415,27 @@ siteVisitView { inventory } m =
                 [ text "Nothing seems to be happening..." ]
 
 
+upkeepView : UpkeepModel -> Html GameMsg
+upkeepView m =
+    div [ class "ready-status" ]
+        [ div [ class "box-text" ] [ text "Time to eat. Share food with anyone going hungry!" ]
+        , div [ class "player-statuses" ] <|
+            List.map
+                (\a ->
+                    div [ class "player-status" ]
+                        [ text a.name
+                        , text <|
+                            if a.short > 0 then
+                                " is short " ++ toString a.short ++ " food"
+
+                            else
+                                " has enough food"
+                        ]
+                )
+                m.players
+        ]
+
+
 gameOverView : Html GameMsg
 gameOverView =
     -- [todo] display winner, stats, etc
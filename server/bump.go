@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// TradeAmbiguous is the reason given when a bump could have been with more
+// than one other player, so no trade was made.
+const TradeAmbiguous string = "ambiguous"
+
+// A BumpIntent is a trade a user wants to make with whoever bumps phones with
+// them at about the same time. The token is optional, and is shared by the two
+// phones when the client can work one out, e.g. from the shake gesture.
+type BumpIntent struct {
+	User      User
+	Time      time.Duration
+	Token     string
+	Materials string
+}
+
+// windowClosed returns true once no other bump can arrive close enough to be
+// matched with this one.
+func (b *BumpIntent) windowClosed(now, timeout time.Duration) bool {
+	return now-b.Time > timeout
+}
+
+// compatible returns true if two bumps could be from the same pair of phones.
+func (b *BumpIntent) compatible(o *BumpIntent, timeout time.Duration) bool {
+	if b.User == o.User {
+		return false
+	}
+	dt := b.Time - o.Time
+	if dt < 0 {
+		dt = -dt
+	}
+	if dt > timeout {
+		return false
+	}
+	return b.Token == "" || o.Token == "" || b.Token == o.Token
+}
+
+// AddBump registers a user's intent to trade by bumping phones. Any earlier
+// intent from the same user is replaced.
+func (g *Game) AddBump(u User, materials, token string) {
+	log.Printf("Bump from %q with token %q", u.Name(), token)
+	g.removeBumps(u)
+	g.bumps = append(g.bumps, &BumpIntent{
+		User:      u,
+		Time:      g.GetTime(),
+		Token:     token,
+		Materials: materials,
+	})
+}
+
+// removeBumps drops any pending bumps from a user.
+func (g *Game) removeBumps(u User) {
+	kept := g.bumps[:0]
+	for _, b := range g.bumps {
+		if b.User != u {
+			kept = append(kept, b)
+		}
+	}
+	g.bumps = kept
+}
+
+// bumpCandidates returns the pending bumps that could be paired with b. If
+// some of them share b's token exactly, only those are considered.
+func (g *Game) bumpCandidates(b *BumpIntent) []*BumpIntent {
+	var loose, exact []*BumpIntent
+	for _, o := range g.bumps {
+		if !b.compatible(o, g.Config.TradeTimeout) {
+			continue
+		}
+		loose = append(loose, o)
+		if b.Token != "" && o.Token == b.Token {
+			exact = append(exact, o)
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return loose
+}
+
+// MatchBumps pairs up the pending bumps whose matching window has closed.
+// Bumps which match exactly one other bump are traded, bumps which match
+// several are reported back as ambiguous, and bumps which match nothing are
+// dropped.
+func (g *Game) MatchBumps() {
+	resolved := map[*BumpIntent]bool{}
+	now := g.GetTime()
+
+	for _, b := range g.bumps {
+		if resolved[b] || !b.windowClosed(now, g.Config.TradeTimeout) {
+			continue
+		}
+
+		candidates := g.bumpCandidates(b)
+		switch len(candidates) {
+		case 0:
+			log.Printf("Bump from %q matched nobody", b.User.Name())
+			b.User.Message(NewTradeClosedMessage(0, TradeExpired, "nobody bumped with you"))
+			resolved[b] = true
+		case 1:
+			o := candidates[0]
+			if resolved[o] || !o.windowClosed(now, g.Config.TradeTimeout) {
+				// Wait until the other side can't be matched with anyone
+				// else either.
+				continue
+			}
+			back := g.bumpCandidates(o)
+			if len(back) == 1 {
+				g.executeTrade(b.User, b.Materials, o.User, o.Materials)
+				resolved[b] = true
+				resolved[o] = true
+				continue
+			}
+			g.reportAmbiguous(append(back, o), resolved)
+		default:
+			g.reportAmbiguous(append(candidates, b), resolved)
+		}
+	}
+
+	kept := g.bumps[:0]
+	for _, b := range g.bumps {
+		if !resolved[b] {
+			kept = append(kept, b)
+		}
+	}
+	g.bumps = kept
+}
+
+// reportAmbiguous tells everyone in a group of bumps that they couldn't be
+// told apart, and marks them all as resolved.
+func (g *Game) reportAmbiguous(group []*BumpIntent, resolved map[*BumpIntent]bool) {
+	names := []string{}
+	for _, b := range group {
+		names = append(names, b.User.Name())
+	}
+	detail := "bumped at the same time as " + strings.Join(names, ", ")
+	log.Printf("Ambiguous bump: %s", detail)
+
+	for _, b := range group {
+		if resolved[b] {
+			continue
+		}
+		b.User.Message(NewTradeClosedMessage(0, TradeAmbiguous, detail))
+		resolved[b] = true
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBumpsWithinWindowTrade(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Log] = 1
+	g.Inventory(bob)[Food] = 1
+
+	g.RecieveMessage(alice, NewTradeMessage(`{"log":1}`, ""))
+	c.Advance(TickInterval)
+	g.RecieveMessage(bob, NewTradeMessage(`{"food":1}`, ""))
+	c.Advance(g.Config.TradeTimeout)
+
+	if _, ok := findMessage[TradeCompletedMessage](alice.messages, nil); !ok {
+		t.Errorf("alice's trade wasn't completed")
+	}
+	if g.Inventory(alice)[Food] != 1 || g.Inventory(bob)[Log] != 1 {
+		t.Errorf("inventories = %v and %v, want the goods swapped", g.Inventory(alice), g.Inventory(bob))
+	}
+	if len(g.bumps) != 0 {
+		t.Errorf("bumps = %v after trading, want none", g.bumps)
+	}
+}
+
+func TestBumpsOutsideWindowDontTrade(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Log] = 1
+	g.Inventory(bob)[Food] = 1
+
+	g.RecieveMessage(alice, NewTradeMessage(`{"log":1}`, ""))
+	c.Advance(g.Config.TradeTimeout)
+	g.RecieveMessage(bob, NewTradeMessage(`{"food":1}`, ""))
+	c.Advance(g.Config.TradeTimeout)
+
+	for _, u := range []*fakeUser{alice, bob} {
+		if got := lastMessage[TradeClosedMessage](t, u.messages, nil).Reason; got != TradeExpired {
+			t.Errorf("%s's reason = %q, want %q", u.name, got, TradeExpired)
+		}
+	}
+	if g.Inventory(alice)[Log] != 1 || g.Inventory(bob)[Food] != 1 {
+		t.Errorf("inventories = %v and %v, want nothing traded", g.Inventory(alice), g.Inventory(bob))
+	}
+}
+
+func TestThreeBumpsAreAmbiguous(t *testing.T) {
+	alice, bob, carol := newFakeUser("alice"), newFakeUser("bob"), newFakeUser("carol")
+	g, _, c := newTestGame(alice, bob, carol)
+	g.Inventory(alice)[Log] = 1
+
+	g.RecieveMessage(alice, NewTradeMessage(`{"log":1}`, ""))
+	g.RecieveMessage(bob, NewTradeMessage(`{}`, ""))
+	g.RecieveMessage(carol, NewTradeMessage(`{}`, ""))
+	c.Advance(g.Config.TradeTimeout)
+
+	for _, u := range []*fakeUser{alice, bob, carol} {
+		if got := lastMessage[TradeClosedMessage](t, u.messages, nil).Reason; got != TradeAmbiguous {
+			t.Errorf("%s's reason = %q, want %q", u.name, got, TradeAmbiguous)
+		}
+	}
+	if got := g.Inventory(alice)[Log]; got != 1 {
+		t.Errorf("alice has %d logs, want 1", got)
+	}
+}
+
+func TestBumpTokensSeparateConcurrentPairs(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	carol, dave := newFakeUser("carol"), newFakeUser("dave")
+	g, _, c := newTestGame(alice, bob, carol, dave)
+	g.Inventory(alice)[Log] = 1
+	g.Inventory(carol)[Bullet] = 1
+
+	g.RecieveMessage(alice, NewTradeMessage(`{"log":1}`, "a"))
+	g.RecieveMessage(carol, NewTradeMessage(`{"bullet":1}`, "c"))
+	g.RecieveMessage(bob, NewTradeMessage(`{}`, "a"))
+	g.RecieveMessage(dave, NewTradeMessage(`{}`, "c"))
+	c.Advance(g.Config.TradeTimeout)
+
+	if g.Inventory(bob)[Log] != 1 || g.Inventory(dave)[Bullet] != 1 {
+		t.Errorf("inventories = %v and %v, want bob to get the log and dave the bullet", g.Inventory(bob), g.Inventory(dave))
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// MaxConfigDuration caps every duration in a GameConfig.
+	MaxConfigDuration time.Duration = time.Hour
+	// MaxConfigCount caps every count in a GameConfig.
+	MaxConfigCount int = 100
+)
+
+// GameConfig holds the rules of a single game. The defaults are the
+// constants of the same names, and the creator of a game can change them,
+// e.g. for a quick demo game.
+type GameConfig struct {
+	MinPlayers         int `json:"min_players"`
+	MaxHealth          int `json:"max_health"`
+	NumDays            int `json:"num_days"`
+	NumSiteVisitRounds int `json:"num_site_visit_rounds"`
+	MinEventsPerVisit  int `json:"min_events_per_visit"`
+	MaxEventsPerVisit  int `json:"max_events_per_visit"`
+	MaxObservedAttacks int `json:"max_observed_attacks"`
+	FoodPerDay         int `json:"food_per_day"`
+	StarvationDamage   int `json:"starvation_damage"`
+
+	SiteSelectionDuration   time.Duration `json:"site_selection_duration"`
+	SiteVisitRoundDuration  time.Duration `json:"site_visit_round_duration"`
+	SiteVisitStatusDuration time.Duration `json:"site_visit_status_duration"`
+	TradeTimeout            time.Duration `json:"trade_timeout"`
+	TradeOfferTimeout       time.Duration `json:"trade_offer_timeout"`
+	UpkeepDuration          time.Duration `json:"upkeep_duration"`
+
+	InitialRepairState uint64 `json:"initial_repair_state"`
+	RepairPerLog       int    `json:"repair_per_log"`
+	RepairDecay        int    `json:"repair_decay"`
+	AttackRepairDamage int    `json:"attack_repair_damage"`
+
+	// RaftCost is what each player at the beach has to contribute to the
+	// raft for them all to leave.
+	RaftCost map[CommodityType]int `json:"raft_cost"`
+}
+
+// DefaultGameConfig returns the rules games are played with unless their
+// creator says otherwise.
+func DefaultGameConfig() GameConfig {
+	return GameConfig{
+		MinPlayers:              MinPlayers,
+		MaxHealth:               DefaultMaxHealth,
+		NumDays:                 DefaultNumDays,
+		NumSiteVisitRounds:      NumSiteVisitRounds,
+		MinEventsPerVisit:       MinEventsPerVisit,
+		MaxEventsPerVisit:       MaxEventsPerVisit,
+		MaxObservedAttacks:      MaxObservedAttacks,
+		FoodPerDay:              FoodPerDay,
+		StarvationDamage:        StarvationDamage,
+		SiteSelectionDuration:   SiteSelectionDuration,
+		SiteVisitRoundDuration:  SiteVisitRoundDuration,
+		SiteVisitStatusDuration: SiteVisitStatusDuration,
+		TradeTimeout:            TradeTimeout,
+		TradeOfferTimeout:       TradeOfferTimeout,
+		UpkeepDuration:          UpkeepDuration,
+		InitialRepairState:      InitialRepairState,
+		RepairPerLog:            RepairPerLog,
+		RepairDecay:             RepairDecay,
+		AttackRepairDamage:      AttackRepairDamage,
+		RaftCost:                map[CommodityType]int{Log: 1},
+	}
+}
+
+// plainGameConfig is a GameConfig without its JSON methods.
+type plainGameConfig GameConfig
+
+// configJSON is a GameConfig as it is written as JSON. Durations are strings
+// such as "3s", the same as ParseGameConfig takes, so a config sent out by
+// the server can be given back to it as it is.
+type configJSON struct {
+	plainGameConfig
+	SiteSelectionDuration   string `json:"site_selection_duration"`
+	SiteVisitRoundDuration  string `json:"site_visit_round_duration"`
+	SiteVisitStatusDuration string `json:"site_visit_status_duration"`
+	TradeTimeout            string `json:"trade_timeout"`
+	TradeOfferTimeout       string `json:"trade_offer_timeout"`
+	UpkeepDuration          string `json:"upkeep_duration"`
+}
+
+// durations pairs each duration in the JSON with the one in the config.
+func (j *configJSON) durations(c *GameConfig) map[*string]*time.Duration {
+	return map[*string]*time.Duration{
+		&j.SiteSelectionDuration:   &c.SiteSelectionDuration,
+		&j.SiteVisitRoundDuration:  &c.SiteVisitRoundDuration,
+		&j.SiteVisitStatusDuration: &c.SiteVisitStatusDuration,
+		&j.TradeTimeout:            &c.TradeTimeout,
+		&j.TradeOfferTimeout:       &c.TradeOfferTimeout,
+		&j.UpkeepDuration:          &c.UpkeepDuration,
+	}
+}
+
+// MarshalJSON writes the config with its durations as strings.
+func (c GameConfig) MarshalJSON() ([]byte, error) {
+	j := configJSON{plainGameConfig: plainGameConfig(c)}
+	for s, d := range j.durations(&c) {
+		*s = d.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON reads a config written by MarshalJSON.
+func (c *GameConfig) UnmarshalJSON(data []byte) error {
+	j := configJSON{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*c = GameConfig(j.plainGameConfig)
+	for s, d := range j.durations(c) {
+		if *s == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(*s)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+	}
+	return nil
+}
+
+// configBound is the range a count in a GameConfig must be in.
+type configBound struct {
+	name            string
+	value, min, max int
+}
+
+// Validate checks that the config describes a playable game.
+func (c GameConfig) Validate() error {
+	counts := []configBound{
+		{"min_players", c.MinPlayers, 1, MaxConfigCount},
+		{"max_health", c.MaxHealth, 1, MaxConfigCount},
+		{"num_days", c.NumDays, 1, MaxConfigCount},
+		// There's always room for the repair and treatment events.
+		{"num_site_visit_rounds", c.NumSiteVisitRounds, 2, MaxConfigCount},
+		{"min_events_per_visit", c.MinEventsPerVisit, 0, MaxConfigCount},
+		{"max_events_per_visit", c.MaxEventsPerVisit, c.MinEventsPerVisit, MaxConfigCount},
+		{"max_observed_attacks", c.MaxObservedAttacks, 0, MaxConfigCount},
+		{"food_per_day", c.FoodPerDay, 0, MaxConfigCount},
+		{"starvation_damage", c.StarvationDamage, 0, MaxConfigCount},
+		{"repair_per_log", c.RepairPerLog, 0, int(MaxRepairState)},
+		{"repair_decay", c.RepairDecay, 0, int(MaxRepairState)},
+		{"attack_repair_damage", c.AttackRepairDamage, 0, int(MaxRepairState)},
+	}
+	for _, r := range AllCommodities {
+		counts = append(counts, configBound{"raft_" + string(r), c.RaftCost[r], 0, MaxConfigCount})
+	}
+	for _, n := range counts {
+		if n.value < n.min || n.value > n.max {
+			return fmt.Errorf("%s is %d, but must be between %d and %d", n.name, n.value, n.min, n.max)
+		}
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"site_selection_duration", c.SiteSelectionDuration},
+		{"site_visit_round_duration", c.SiteVisitRoundDuration},
+		{"site_visit_status_duration", c.SiteVisitStatusDuration},
+		{"trade_timeout", c.TradeTimeout},
+		{"trade_offer_timeout", c.TradeOfferTimeout},
+		{"upkeep_duration", c.UpkeepDuration},
+	}
+	for _, d := range durations {
+		if d.value < TickInterval || d.value > MaxConfigDuration {
+			return fmt.Errorf("%s is %v, but must be between %v and %v", d.name, d.value, TickInterval, MaxConfigDuration)
+		}
+	}
+
+	if c.InitialRepairState > MaxRepairState {
+		return fmt.Errorf("initial_repair_state is %d, but must be at most %d", c.InitialRepairState, MaxRepairState)
+	}
+	for r, _ := range c.RaftCost {
+		if !isCommodity(r) {
+			return fmt.Errorf("raft needs unknown resource %q", r)
+		}
+	}
+	return nil
+}
+
+// ResourceRequiredToLeave returns what a group of players at the beach have
+// to contribute between them to build a raft.
+func (c GameConfig) ResourceRequiredToLeave(numPlayers int) map[CommodityType]int {
+	required := map[CommodityType]int{}
+	for r, n := range c.RaftCost {
+		required[r] = n * numPlayers
+	}
+	return required
+}
+
+// ParseGameConfig reads a config from URL parameters named after its JSON
+// fields, e.g. num_days=2&site_visit_round_duration=3s. The raft cost is
+// given per resource, e.g. raft_log=2. Anything not given keeps its default,
+// and the result is validated.
+func ParseGameConfig(params url.Values) (GameConfig, error) {
+	c := DefaultGameConfig()
+
+	counts := map[string]*int{
+		"min_players":           &c.MinPlayers,
+		"max_health":            &c.MaxHealth,
+		"num_days":              &c.NumDays,
+		"num_site_visit_rounds": &c.NumSiteVisitRounds,
+		"min_events_per_visit":  &c.MinEventsPerVisit,
+		"max_events_per_visit":  &c.MaxEventsPerVisit,
+		"max_observed_attacks":  &c.MaxObservedAttacks,
+		"food_per_day":          &c.FoodPerDay,
+		"starvation_damage":     &c.StarvationDamage,
+		"repair_per_log":        &c.RepairPerLog,
+		"repair_decay":          &c.RepairDecay,
+		"attack_repair_damage":  &c.AttackRepairDamage,
+	}
+	for key, p := range counts {
+		if s := params.Get(key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return c, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*p = n
+		}
+	}
+	for _, r := range AllCommodities {
+		key := "raft_" + string(r)
+		if s := params.Get(key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return c, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			c.RaftCost[r] = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"site_selection_duration":    &c.SiteSelectionDuration,
+		"site_visit_round_duration":  &c.SiteVisitRoundDuration,
+		"site_visit_status_duration": &c.SiteVisitStatusDuration,
+		"trade_timeout":              &c.TradeTimeout,
+		"trade_offer_timeout":        &c.TradeOfferTimeout,
+		"upkeep_duration":            &c.UpkeepDuration,
+	}
+	for key, p := range durations {
+		if s := params.Get(key); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return c, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*p = d
+		}
+	}
+
+	if s := params.Get("initial_repair_state"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid initial_repair_state: %v", err)
+		}
+		c.InitialRepairState = n
+	}
+	return c, c.Validate()
+}
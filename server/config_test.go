@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGameConfig(t *testing.T) {
+	c, err := ParseGameConfig(url.Values{})
+	if err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+	if c.NumDays != DefaultNumDays || c.SiteVisitRoundDuration != SiteVisitRoundDuration {
+		t.Errorf("config without parameters = %+v, want the defaults", c)
+	}
+
+	c, err = ParseGameConfig(url.Values{
+		"num_days":                  {"2"},
+		"site_visit_round_duration": {"3s"},
+		"initial_repair_state":      {"80"},
+		"raft_food":                 {"2"},
+	})
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	if c.NumDays != 2 || c.SiteVisitRoundDuration != 3*time.Second || c.InitialRepairState != 80 {
+		t.Errorf("config = %+v", c)
+	}
+	if c.RaftCost[Food] != 2 || c.RaftCost[Log] != 1 {
+		t.Errorf("raft cost = %v, want 1 log and 2 food", c.RaftCost)
+	}
+}
+
+func TestParseGameConfigRejectsInvalid(t *testing.T) {
+	for _, params := range []url.Values{
+		{"num_days": {"two"}},
+		{"num_days": {"0"}},
+		{"min_players": {"1000"}},
+		{"min_events_per_visit": {"3"}, "max_events_per_visit": {"2"}},
+		{"num_site_visit_rounds": {"1"}},
+		{"site_visit_round_duration": {"1ms"}},
+		{"trade_offer_timeout": {"3h"}},
+		{"initial_repair_state": {"101"}},
+		{"repair_decay": {"101"}},
+		{"raft_log": {"-1"}},
+	} {
+		if c, err := ParseGameConfig(params); err == nil {
+			t.Errorf("parsed %v as %+v, want an error", params, c)
+		}
+	}
+}
+
+func TestGameUsesConfig(t *testing.T) {
+	config := DefaultGameConfig()
+	config.MinPlayers = 2
+	config.MaxHealth = 5
+	config.InitialRepairState = 10
+	g := NewGameWithConfig("test", &fakeConnection{}, 1, config)
+
+	alice := newFakeUser("alice")
+	g.RecieveMessage(alice, NewJoinMessage())
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	if g.state.Name() != WaitingState {
+		t.Errorf("state = %q with one of two players, want %q", g.state.Name(), WaitingState)
+	}
+	if got := g.Health[alice]; got != 5 {
+		t.Errorf("health = %d, want 5", got)
+	}
+	if got := g.SiteRepairState[Farm]; got != 10 {
+		t.Errorf("farm repair = %d, want 10", got)
+	}
+}
+
+func TestConfigJSONCanBeSentBack(t *testing.T) {
+	config := DefaultGameConfig()
+	config.SiteVisitRoundDuration = 3 * time.Second
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("unable to encode config: %v", err)
+	}
+	if !strings.Contains(string(data), `"site_visit_round_duration":"3s"`) {
+		t.Errorf("config = %s, want durations like the parameters", data)
+	}
+
+	// Every value can be given back as a parameter.
+	fields := map[string]interface{}{}
+	json.Unmarshal(data, &fields)
+	params := url.Values{}
+	for key, value := range fields {
+		if key != "raft_cost" {
+			params.Set(key, fmt.Sprint(value))
+		}
+	}
+	for r, n := range config.RaftCost {
+		params.Set("raft_"+string(r), fmt.Sprint(n))
+	}
+	if parsed, err := ParseGameConfig(params); err != nil || !reflect.DeepEqual(parsed, config) {
+		t.Errorf("parsed %v as %+v, %v, want %+v", params, parsed, err, config)
+	}
+
+	decoded := GameConfig{}
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, config) {
+		t.Errorf("decoded %s as %+v, %v, want %+v", data, decoded, err, config)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+)
+
+const (
+	// ObservedAttackChance is how likely each of the MaxObservedAttacks is
+	// to happen during a visit, out of 1000.
+	ObservedAttackChance int = 500
+
+	// ObservedAttackStrength is how many hits it takes to drive off an
+	// attack seen from the watchtower. Fewer hits only weaken it.
+	ObservedAttackStrength int = 2
+
+	// MinHitChance and MaxHitChance are the percentage chance of each
+	// bullet fired from the watchtower hitting, from a broken down to a
+	// fully repaired watchtower.
+	MinHitChance int = 30
+	MaxHitChance int = 80
+)
+
+// DefenseOutcome is how the watchtower did against an attack.
+type DefenseOutcome string
+
+const (
+	// AttackRepelled means the attack was driven off.
+	AttackRepelled DefenseOutcome = "repelled"
+	// AttackWeakened means the attack went ahead, but did less damage.
+	AttackWeakened DefenseOutcome = "weakened"
+	// AttackLanded means the attack went ahead in full.
+	AttackLanded DefenseOutcome = "landed"
+)
+
+// A Defense is an attack on a site seen from the watchtower. Everyone at the
+// watchtower is asked how many bullets to fire at it, and once they have all
+// answered the shots decide what happens.
+type Defense struct {
+	site      Site
+	defenders []User
+	shots     map[User]int
+}
+
+// NewDefense constructs a defense of the site against an attack, by the
+// given users.
+func NewDefense(site Site, defenders []User) *Defense {
+	return &Defense{
+		site:      site,
+		defenders: defenders,
+		shots:     map[User]int{},
+	}
+}
+
+// Fire records how many bullets a defender fired.
+func (d *Defense) Fire(u User, bullets int) {
+	d.shots[u] = bullets
+}
+
+// ready returns true once every defender still alive has fired, or held
+// their fire.
+func (d *Defense) ready(g *Game) bool {
+	for _, u := range d.defenders {
+		if _, ok := d.shots[u]; !ok && g.IsAlive(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// hitChance returns the percentage chance of a bullet fired from the
+// watchtower hitting.
+func hitChance(g *Game) int {
+	repair := int(g.SiteRepairState[Watchtower])
+	return MinHitChance + (MaxHitChance-MinHitChance)*repair/int(MaxRepairState)
+}
+
+// resolveDefenses settles every attack whose defenders have all fired.
+func (s *SiteVisitController) resolveDefenses() {
+	pending := s.defenses[:0]
+	for _, d := range s.defenses {
+		if d.ready(s.game) {
+			s.resolveDefense(d)
+		} else {
+			pending = append(pending, d)
+		}
+	}
+	s.defenses = pending
+}
+
+// resolveDefense decides whether the bullets fired at an attack drove it off,
+// weakened it or didn't stop it at all, and tells everyone who defended the
+// site.
+func (s *SiteVisitController) resolveDefense(d *Defense) {
+	chance := hitChance(s.game)
+	hits := 0
+	var defenders []DefenderInfo
+	for _, u := range d.defenders {
+		bullets := d.shots[u]
+		if bullets <= 0 {
+			continue
+		}
+		defenders = append(defenders, DefenderInfo{Name: u.Name(), Bullets: bullets})
+		for i := 0; i < bullets; i++ {
+			if s.game.rng.Intn(100) < chance {
+				hits++
+			}
+		}
+	}
+
+	outcome := AttackLanded
+	switch {
+	case hits >= ObservedAttackStrength:
+		outcome = AttackRepelled
+	case hits > 0:
+		outcome = AttackWeakened
+		s.attackSite(d.site, NewWeakenedAttack(), s.game.Config.AttackRepairDamage/2)
+	default:
+		s.DefenseFailed(d.site)
+	}
+
+	log.Printf("Attack on the %s %s with %d hits", d.site, outcome, hits)
+	s.game.connection.Broadcast(NewDefenseSummaryMessage(d.site, outcome, defenders))
+}
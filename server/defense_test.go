@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+// startWatch sends alice and bob to the watchtower, and carol to the farm.
+func startWatch(t *testing.T) (*Game, *fakeConnection, *testClock, *SiteVisitController, []*fakeUser) {
+	t.Helper()
+	alice, bob, carol := newFakeUser("alice"), newFakeUser("bob"), newFakeUser("carol")
+	g, conn, c := newTestGame(alice, bob, carol)
+	users := []*fakeUser{alice, bob, carol}
+	for _, u := range users {
+		g.RecieveMessage(u, NewReadyMessage(true))
+	}
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Watchtower))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Watchtower))
+	g.RecieveMessage(carol, NewSiteSelectionMessage(Farm))
+	return g, conn, c, g.state.(*SiteVisitController), users
+}
+
+func TestEveryDefenderSeesTheAttack(t *testing.T) {
+	g, _, c, s, users := startWatch(t)
+	alice, bob, carol := users[0], users[1], users[2]
+
+	defense := NewDefense(Farm, []User{alice, bob})
+	s.defenses = []*Defense{defense}
+	event := NewObserveAttack(defense)
+	s.userEventQueue[alice] = []SiteEvent{event}
+	s.userEventQueue[bob] = []SiteEvent{event}
+	s.userEventQueue[carol] = nil
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+
+	a, b := alice.lastEvent(t), bob.lastEvent(t)
+	if a.Title != b.Title || a.MessageID == b.MessageID {
+		t.Fatalf("alice got %+v and bob got %+v, want the same attack", a, b)
+	}
+
+	g.Inventory(alice)[Bullet] = 10
+	g.Inventory(bob)[Bullet] = 10
+	g.SiteRepairState[Watchtower] = MaxRepairState
+	g.RecieveMessage(alice, NewEventResponseMessage(a.MessageID, true, false, 10))
+	if len(s.defenses) != 1 {
+		t.Fatalf("the attack was settled before bob fired")
+	}
+	g.RecieveMessage(bob, NewEventResponseMessage(b.MessageID, true, false, 10))
+	c.Advance(SiteVisitRoundDuration)
+
+	if len(s.defenses) != 0 {
+		t.Fatalf("the attack wasn't settled once everyone fired")
+	}
+	if len(s.userEventQueue[carol]) != 0 {
+		t.Errorf("carol was attacked after 20 bullets were fired, got %v", s.userEventQueue[carol])
+	}
+}
+
+func TestDefenseSummary(t *testing.T) {
+	g, conn, _, s, users := startWatch(t)
+	alice, bob := users[0], users[1]
+
+	defense := NewDefense(Farm, []User{alice, bob})
+	defense.Fire(alice, 0)
+	defense.Fire(bob, 10)
+	g.SiteRepairState[Watchtower] = MaxRepairState
+	s.resolveDefense(defense)
+
+	summary := lastMessage[DefenseSummaryMessage](t, conn.broadcasts, nil)
+	if summary.Site != Farm || summary.Outcome != AttackRepelled {
+		t.Errorf("summary = %+v, want the farm defended", summary)
+	}
+	if len(summary.Defenders) != 1 || summary.Defenders[0].Name != "bob" || summary.Defenders[0].Bullets != 10 {
+		t.Errorf("defenders = %+v, want bob with 10 bullets", summary.Defenders)
+	}
+}
+
+func TestOneBulletOnlyWeakensAttack(t *testing.T) {
+	g, conn, _, s, users := startWatch(t)
+	alice, carol := users[0], users[2]
+	g.SiteRepairState[Watchtower] = MaxRepairState
+
+	weakened := false
+	for i := 0; i < 20 && !weakened; i++ {
+		s.userEventQueue[carol] = nil
+		defense := NewDefense(Farm, []User{alice})
+		defense.Fire(alice, 1)
+		s.resolveDefense(defense)
+
+		switch outcome := lastMessage[DefenseSummaryMessage](t, conn.broadcasts, nil).Outcome; outcome {
+		case AttackRepelled:
+			t.Fatalf("one bullet repelled an attack")
+		case AttackWeakened:
+			weakened = true
+			if attack, ok := s.userEventQueue[carol][0].(Attack); !ok || !attack.weakened {
+				t.Errorf("carol's next event = %v, want a weakened attack", s.userEventQueue[carol][0])
+			}
+		}
+	}
+	if !weakened {
+		t.Errorf("one bullet from a fully repaired watchtower never hit")
+	}
+}
+
+func TestUnseenAttackLands(t *testing.T) {
+	g, conn, _, s, users := startWatch(t)
+	carol := users[2]
+	s.userEventQueue[carol] = nil
+
+	s.resolveDefense(NewDefense(Farm, nil))
+	summary := lastMessage[DefenseSummaryMessage](t, conn.broadcasts, nil)
+	if summary.Outcome != AttackLanded || len(summary.Defenders) != 0 {
+		t.Errorf("summary = %+v, want an undefended attack", summary)
+	}
+	if attack, ok := s.userEventQueue[carol][0].(Attack); !ok || attack.weakened {
+		t.Errorf("carol's next event = %v, want a full attack", s.userEventQueue[carol][0])
+	}
+	if got, want := g.SiteRepairState[Farm], InitialRepairState-uint64(AttackRepairDamage); got > want {
+		t.Errorf("farm repair = %d, want at most %d", got, want)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+// RandomEvents are the events which can randomly happen to a user during a
+// site visit. Events loaded from definition files are added to these.
+var RandomEvents []SiteEvent = []SiteEvent{
+	NewGetResource(),
+	NewAttack(),
+	NewHospitalTreatment(),
+}
+
+// EventDefinition describes a site event authored in a definition file. The
+// title and description, including those of the outcomes, are templates
+// which can refer to {{.Site}}, {{.Player}} and {{.Repair}}.
+type EventDefinition struct {
+	ID          string `json:"id"`
+	Sites       []Site `json:"sites"`
+	Weight      int    `json:"weight"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	// Buttons. The OK button is always shown, with the given text or "OK".
+	OKButton string            `json:"ok_button,omitempty"`
+	Action   *ActionDefinition `json:"action,omitempty"`
+	Spend    CommodityType     `json:"spend,omitempty"`
+
+	// If set, the event is resolved at the end of the round even if the
+	// user doesn't respond.
+	StatusUpdate bool `json:"status_update,omitempty"`
+
+	// Success happens if the user clicks the action button or spends
+	// something. Otherwise, failure happens.
+	Success *OutcomeDefinition `json:"success,omitempty"`
+	Failure *OutcomeDefinition `json:"failure,omitempty"`
+
+	// Generation rules. See GenerationRules.
+	Rarity         Rarity          `json:"rarity,omitempty"`
+	Conditions     EventConditions `json:"conditions,omitempty"`
+	PlayerCooldown int             `json:"player_cooldown,omitempty"`
+	GameCooldown   int             `json:"game_cooldown,omitempty"`
+}
+
+// ActionDefinition describes an action button. A positive amount is spent by
+// the user, a negative amount is given to them.
+type ActionDefinition struct {
+	Text     string        `json:"text"`
+	Resource CommodityType `json:"resource"`
+	Amount   int           `json:"amount"`
+}
+
+// OutcomeDefinition describes what happens when an event is resolved. If it
+// has a title, a status update is sent to the user.
+type OutcomeDefinition struct {
+	Title       string                `json:"title,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Health      int                   `json:"health,omitempty"`
+	Resources   map[CommodityType]int `json:"resources,omitempty"`
+}
+
+// eventTemplateData is what event templates are rendered with.
+type eventTemplateData struct {
+	Site   Site
+	Player string
+	Repair uint64
+}
+
+// DefinedEvent is a SiteEvent compiled from an EventDefinition.
+type DefinedEvent struct {
+	def         *EventDefinition
+	title       *template.Template
+	description *template.Template
+	success     *compiledOutcome
+	failure     *compiledOutcome
+}
+
+type compiledOutcome struct {
+	def         *OutcomeDefinition
+	title       *template.Template
+	description *template.Template
+}
+
+func isCommodity(c CommodityType) bool {
+	for _, k := range AllCommodities {
+		if c == k {
+			return true
+		}
+	}
+	return false
+}
+
+func isSite(s Site) bool {
+	for _, k := range AllSites() {
+		if s == k {
+			return true
+		}
+	}
+	return false
+}
+
+func compileOutcome(id, name string, def *OutcomeDefinition) (*compiledOutcome, error) {
+	if def == nil {
+		return nil, nil
+	}
+	if def.Title == "" && def.Health != 0 {
+		return nil, fmt.Errorf("event %q: %s outcome changes health, so it needs a title", id, name)
+	}
+	for c, _ := range def.Resources {
+		if !isCommodity(c) {
+			return nil, fmt.Errorf("event %q: %s outcome has unknown resource %q", id, name, c)
+		}
+	}
+
+	title, err := template.New(id + "." + name + ".title").Parse(def.Title)
+	if err != nil {
+		return nil, fmt.Errorf("event %q: %v", id, err)
+	}
+	description, err := template.New(id + "." + name + ".description").Parse(def.Description)
+	if err != nil {
+		return nil, fmt.Errorf("event %q: %v", id, err)
+	}
+	return &compiledOutcome{def, title, description}, nil
+}
+
+// CompileEventDefinition checks a definition and turns it into a SiteEvent.
+func CompileEventDefinition(def *EventDefinition) (*DefinedEvent, error) {
+	if def.ID == "" {
+		return nil, fmt.Errorf("event with title %q has no id", def.Title)
+	}
+	if len(def.Sites) == 0 {
+		return nil, fmt.Errorf("event %q doesn't happen at any site", def.ID)
+	}
+	for _, s := range def.Sites {
+		if !isSite(s) || s == Beach {
+			return nil, fmt.Errorf("event %q: can't happen at site %q", def.ID, s)
+		}
+	}
+	if def.Weight < 0 || def.Weight > 1000 {
+		return nil, fmt.Errorf("event %q: weight %d is not between 0 and 1000", def.ID, def.Weight)
+	}
+	if def.Action != nil && (!isCommodity(def.Action.Resource) || def.Action.Amount == 0) {
+		return nil, fmt.Errorf("event %q: invalid action %+v", def.ID, *def.Action)
+	}
+	if def.Spend != "" && !isCommodity(def.Spend) {
+		return nil, fmt.Errorf("event %q: can't spend unknown resource %q", def.ID, def.Spend)
+	}
+	if _, ok := rarityScale[def.Rarity]; def.Rarity != "" && !ok {
+		return nil, fmt.Errorf("event %q: unknown rarity %q", def.ID, def.Rarity)
+	}
+	for c, _ := range def.Conditions.Requires {
+		if !isCommodity(c) {
+			return nil, fmt.Errorf("event %q: requires unknown resource %q", def.ID, c)
+		}
+	}
+
+	e := &DefinedEvent{def: def}
+	var err error
+	if e.title, err = template.New(def.ID + ".title").Parse(def.Title); err != nil {
+		return nil, fmt.Errorf("event %q: %v", def.ID, err)
+	}
+	if e.description, err = template.New(def.ID + ".description").Parse(def.Description); err != nil {
+		return nil, fmt.Errorf("event %q: %v", def.ID, err)
+	}
+	if e.success, err = compileOutcome(def.ID, "success", def.Success); err != nil {
+		return nil, err
+	}
+	if e.failure, err = compileOutcome(def.ID, "failure", def.Failure); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// LoadEventDefinitions reads every .json file in dir, each holding a list of
+// event definitions, and compiles them.
+func LoadEventDefinitions(dir string) ([]SiteEvent, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	events := []SiteEvent{}
+	seen := map[string]string{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defs := []*EventDefinition{}
+		if err := json.Unmarshal(data, &defs); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		for _, def := range defs {
+			if other, ok := seen[def.ID]; ok {
+				return nil, fmt.Errorf("%s: event %q is already defined in %s", path, def.ID, other)
+			}
+			seen[def.ID] = path
+
+			event, err := CompileEventDefinition(def)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			events = append(events, event)
+		}
+	}
+
+	log.Printf("Loaded %d event definitions from %s", len(events), dir)
+	return events, nil
+}
+
+func render(t *template.Template, data eventTemplateData) string {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		log.Printf("Unable to render %s: %v", t.Name(), err)
+	}
+	return buf.String()
+}
+
+func (e *DefinedEvent) templateData(g *Game, u User) eventTemplateData {
+	site := g.UserSites[u]
+	return eventTemplateData{
+		Site:   site,
+		Player: u.Name(),
+		Repair: g.SiteRepairState[site],
+	}
+}
+
+func (e *DefinedEvent) Mods(g *Game, u User) int {
+	site := g.UserSites[u]
+	for _, s := range e.def.Sites {
+		if s == site {
+			return e.def.Weight
+		}
+	}
+	return 0
+}
+
+func (e *DefinedEvent) Rules() GenerationRules {
+	return GenerationRules{
+		ID:             e.def.ID,
+		Rarity:         e.def.Rarity,
+		Conditions:     e.def.Conditions,
+		PlayerCooldown: e.def.PlayerCooldown,
+		GameCooldown:   e.def.GameCooldown,
+	}
+}
+
+func (e *DefinedEvent) Begin(g *Game, u User) EventMessage {
+	data := e.templateData(g, u)
+	msg := NewEventMessage(render(e.title, data), render(e.description, data))
+	if e.def.OKButton != "" {
+		msg.WithOKButton(e.def.OKButton)
+	}
+	if e.def.Action != nil {
+		msg.WithActionButton(e.def.Action.Text, e.def.Action.Resource, e.def.Action.Amount)
+	}
+	if e.def.Spend != "" {
+		msg.WithSpendButton(e.def.Spend)
+	}
+	msg.HasSubsequentStatusUpdate = e.def.StatusUpdate
+	return msg
+}
+
+func (e *DefinedEvent) End(g *Game, u User, r EventResponseMessage) *EventMessage {
+	outcome := e.failure
+	if r.ClickedAction || r.ResourceAmount > 0 {
+		outcome = e.success
+	}
+	if outcome == nil {
+		return nil
+	}
+
+	// Resources are given, or taken as far as the user has them.
+	for c, n := range outcome.def.Resources {
+		if n > 0 {
+			g.Credit(u, c, n)
+		} else if n < 0 {
+			owned := g.Inventory(u)[c]
+			if -n > owned {
+				n = -owned
+			}
+			g.Debit(u, c, -n)
+		}
+	}
+
+	if outcome.def.Title == "" {
+		return nil
+	}
+	data := e.templateData(g, u)
+	msg := NewEventMessage(render(outcome.title, data), render(outcome.description, data))
+	msg.HealthModifier = outcome.def.Health
+	return &msg
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestShippedEventDefinitionsLoad(t *testing.T) {
+	events, err := LoadEventDefinitions("../events")
+	if err != nil {
+		t.Fatalf("unable to load event definitions: %v", err)
+	}
+	if len(events) == 0 {
+		t.Errorf("no event definitions were loaded")
+	}
+}
+
+func TestCompileEventDefinitionRejectsInvalid(t *testing.T) {
+	defs := []*EventDefinition{
+		{ID: "", Sites: []Site{Forest}},
+		{ID: "nowhere"},
+		{ID: "beach", Sites: []Site{Beach}},
+		{ID: "heavy", Sites: []Site{Forest}, Weight: 2000},
+		{ID: "gold", Sites: []Site{Forest}, Spend: "gold"},
+		{ID: "template", Sites: []Site{Forest}, Title: "{{.Site"},
+		{ID: "silent", Sites: []Site{Forest}, Failure: &OutcomeDefinition{Health: -1}},
+	}
+	for _, def := range defs {
+		if _, err := CompileEventDefinition(def); err == nil {
+			t.Errorf("definition %q compiled, want an error", def.ID)
+		}
+	}
+}
+
+func TestDefinedEvent(t *testing.T) {
+	event, err := CompileEventDefinition(&EventDefinition{
+		ID:           "rats",
+		Sites:        []Site{Farm},
+		Weight:       100,
+		Title:        "Rats at the {{.Site}}!",
+		Spend:        Bullet,
+		StatusUpdate: true,
+		Success:      &OutcomeDefinition{Title: "Saved."},
+		Failure: &OutcomeDefinition{
+			Title:     "Bitten, {{.Player}}.",
+			Health:    -1,
+			Resources: map[CommodityType]int{Food: -2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to compile: %v", err)
+	}
+
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	g.UserSites[alice] = Farm
+	g.Inventory(alice)[Food] = 1
+
+	if got := event.Mods(g, alice); got != 100 {
+		t.Errorf("mods at farm = %d, want 100", got)
+	}
+	msg := event.Begin(g, alice)
+	if msg.Title != "Rats at the farm!" || !msg.HasSpendButton || !msg.HasSubsequentStatusUpdate {
+		t.Errorf("begin = %+v", msg)
+	}
+
+	status := event.End(g, alice, EventResponseMessage{})
+	if status == nil || status.Title != "Bitten, alice." || status.HealthModifier != -1 {
+		t.Errorf("failure status = %+v", status)
+	}
+	if got := g.Inventory(alice)[Food]; got != 0 {
+		t.Errorf("food = %d, want 0", got)
+	}
+
+	status = event.End(g, alice, EventResponseMessage{ResourceAmount: 1})
+	if status == nil || status.Title != "Saved." {
+		t.Errorf("success status = %+v", status)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeUser is an in-memory User which records every message sent to it.
+type fakeUser struct {
+	name     string
+	alive    bool
+	messages []Message
+}
+
+func newFakeUser(name string) *fakeUser {
+	return &fakeUser{name: name, alive: true}
+}
+
+func (u *fakeUser) Message(message Message) error {
+	if !u.alive && message.requiresAlive() {
+		return nil
+	}
+	u.messages = append(u.messages, message)
+	return nil
+}
+
+func (u *fakeUser) Name() string        { return u.name }
+func (u *fakeUser) SetName(name string) { u.name = name }
+func (u *fakeUser) SetAlive(alive bool) { u.alive = alive }
+func (u *fakeUser) Session() string     { return "session-" + u.name }
+
+// findMessage returns the most recent message of type T which satisfies
+// match. A nil match accepts any message of that type.
+func findMessage[T Message](messages []Message, match func(T) bool) (T, bool) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if msg, ok := messages[i].(T); ok && (match == nil || match(msg)) {
+			return msg, true
+		}
+	}
+	var none T
+	return none, false
+}
+
+// lastMessage is like findMessage, but fails the test if there is no such
+// message.
+func lastMessage[T Message](t *testing.T, messages []Message, match func(T) bool) T {
+	t.Helper()
+	msg, ok := findMessage(messages, match)
+	if !ok {
+		t.Fatalf("no %T was sent", msg)
+	}
+	return msg
+}
+
+// lastEvent returns the most recent event sent to the user.
+func (u *fakeUser) lastEvent(t *testing.T) EventMessage {
+	t.Helper()
+	return lastMessage[EventMessage](t, u.messages, nil)
+}
+
+// lastError returns the code of the most recent error sent to the user, or
+// an empty code if there wasn't one.
+func (u *fakeUser) lastError() ErrorCode {
+	msg, _ := findMessage[ErrorMessage](u.messages, nil)
+	return msg.Code
+}
+
+// fakeConnection is an in-memory GameConnection which delivers broadcasts to
+// its users and records them.
+type fakeConnection struct {
+	users      []*fakeUser
+	broadcasts []Message
+	stopped    bool
+}
+
+func (c *fakeConnection) Broadcast(message Message) error {
+	c.broadcasts = append(c.broadcasts, message)
+	for _, u := range c.users {
+		u.Message(message)
+	}
+	return nil
+}
+
+func (c *fakeConnection) Stop() {
+	c.stopped = true
+}
+
+// testClock drives a game's clock by hand, one tick at a time.
+type testClock struct {
+	game *Game
+	now  time.Duration
+}
+
+// Advance moves the game clock forward by at least d, ticking every
+// TickInterval along the way.
+func (c *testClock) Advance(d time.Duration) {
+	end := c.now + d
+	for c.now <= end {
+		c.now += TickInterval
+		c.game.Tick(c.now)
+	}
+}
+
+// newTestGame constructs a predictable game, with the given users joined.
+func newTestGame(users ...*fakeUser) (*Game, *fakeConnection, *testClock) {
+	conn := &fakeConnection{}
+	g := NewGame("test", conn, 1)
+	for _, u := range users {
+		conn.users = append(conn.users, u)
+		g.RecieveMessage(u, NewJoinMessage())
+	}
+	return g, conn, &testClock{game: g}
+}
+
+// connectTestSocket opens a websocket connection to a test server, and
+// returns the server's and the client's end of it. Both are closed when the
+// test finishes.
+func connectTestSocket(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("unable to upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-accepted, client
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math/rand"
 	"time"
 )
 
@@ -34,9 +36,10 @@ const (
 var AllCommodities []CommodityType = []CommodityType{Log, Food, Bandage, Bullet}
 
 const (
-	// TradeTimeout specifies how long a trade can hang without a
-	// counterpart before it is cancelled.
-	TradeTimeout time.Duration = 100 * time.Millisecond
+	// TradeTimeout specifies how far apart two bumps can be and still be
+	// paired into a trade. Game time only advances once per tick, so this
+	// should be at least a tick long.
+	TradeTimeout time.Duration = 2 * TickInterval
 )
 
 // User represents a single connection to a player, e.g. a websocket.
@@ -45,48 +48,94 @@ type User interface {
 	Name() string
 	SetName(name string)
 	SetAlive(alive bool)
+	// Session returns the token the user can resume their session with.
+	Session() string
 }
 
 // GameConnection holds a list of all the active players, and can be
 // used to broadcast messages to all players.
 type GameConnection interface {
 	Broadcast(message Message) error
+	// Stop is called once the game is over, and no more ticks are needed.
+	Stop()
 }
 
 // Game represents the state of an individual game instance.
 type Game struct {
-	name            string
-	connection      GameConnection
-	state           StateController
-	nextTimeout     time.Duration
-	tick            time.Duration
-	MinPlayers      int
-	Yield           map[CommodityType]float64
+	name        string
+	connection  GameConnection
+	seed        int64
+	rng         *rand.Rand
+	state       StateController
+	nextTimeout time.Duration
+	tick        time.Duration
+	// round counts the site visits, or days, so far.
+	round           int
+	events          *EventGenerator
+	Config          GameConfig
 	UserSites       map[User]Site
+	users           []User
 	SiteRepairState map[Site]uint64
+	Inventories     map[User]Inventory
+	Health          map[User]int
+	Statuses        map[User]map[StatusEffect]bool
+	Escaped         map[User]bool
+	Presence        map[User]Presence
 
-	// The user that is proposing a trade right now.
-	stagedUser      User
-	stagingTime     time.Duration
-	stagedMaterials string
+	// Yield is how much of each commodity a single player finds at a
+	// broken down site, before it has been picked over.
+	Yield map[CommodityType]float64
+	// Depletion is how much of each site's resources has been used up,
+	// from 0 to MaxDepletion.
+	Depletion map[Site]float64
+	// The fractions of each commodity each user has found, but not yet
+	// been given.
+	yieldCarry map[User]map[CommodityType]float64
+
+	// The ID of the last event sent to any user.
+	lastEventID uint64
+
+	trades      map[uint64]*Trade
+	nextTradeID uint64
+
+	// Trades waiting to be paired by bumping phones.
+	bumps []*BumpIntent
 }
 
-// NewGame constructs a game.
-func NewGame(name string, connection GameConnection) *Game {
+// NewGame constructs a game with the default rules. All of the game's
+// randomness comes from the seed, so two games with the same seed and the
+// same input play out the same way.
+func NewGame(name string, connection GameConnection, seed int64) *Game {
+	return NewGameWithConfig(name, connection, seed, DefaultGameConfig())
+}
+
+// NewGameWithConfig is like NewGame, but plays by the given rules.
+func NewGameWithConfig(name string, connection GameConnection, seed int64, config GameConfig) *Game {
 	repair_state := map[Site]uint64{}
 	for _, s := range AllSites() {
-		repair_state[s] = InitialRepairState
+		repair_state[s] = config.InitialRepairState
 	}
 
 	game := Game{
 		name:            name,
 		connection:      connection,
+		seed:            seed,
+		rng:             rand.New(rand.NewSource(seed)),
 		state:           nil,
 		Yield:           make(map[CommodityType]float64),
-		MinPlayers:      MinPlayers,
+		Depletion:       map[Site]float64{},
+		Config:          config,
 		UserSites:       map[User]Site{},
 		SiteRepairState: repair_state,
+		Inventories:     map[User]Inventory{},
+		Health:          map[User]int{},
+		Statuses:        map[User]map[StatusEffect]bool{},
+		Escaped:         map[User]bool{},
+		Presence:        map[User]Presence{},
+		yieldCarry:      map[User]map[CommodityType]float64{},
+		trades:          map[uint64]*Trade{},
 	}
+	game.events = NewEventGenerator(&game, append([]SiteEvent{}, RandomEvents...))
 	game.state = NewStateController(&game, WaitingState)
 	game.state.Begin()
 
@@ -105,6 +154,15 @@ func (g *Game) SetTimeout(duration time.Duration) {
 	g.nextTimeout = g.tick + duration
 }
 
+// TimeRemaining returns how long until the current timer goes off, or zero if
+// no timer is set.
+func (g *Game) TimeRemaining() time.Duration {
+	if g.nextTimeout == 0 || g.nextTimeout < g.tick {
+		return 0
+	}
+	return g.nextTimeout - g.tick
+}
+
 // GetTime returns the current time since the game began.
 func (g *Game) GetTime() time.Duration {
 	return g.tick
@@ -113,6 +171,8 @@ func (g *Game) GetTime() time.Duration {
 // Tick is called each time that the tick interval elapses.
 func (g *Game) Tick(time time.Duration) {
 	g.tick = time
+	g.expireTrades()
+	g.MatchBumps()
 
 	// If a timer is currently set, notify the state controller.
 	if g.nextTimeout != 0 && time > g.nextTimeout {
@@ -121,43 +181,225 @@ func (g *Game) Tick(time time.Duration) {
 	}
 }
 
+// NewEventID returns an ID for an event message. IDs are never reused within
+// a game.
+func (g *Game) NewEventID() uint64 {
+	g.lastEventID++
+	return g.lastEventID
+}
+
+// reject tells a user why the server couldn't act on their message.
+func (g *Game) reject(u User, code ErrorCode, format string, args ...interface{}) {
+	detail := fmt.Sprintf(format, args...)
+	log.Printf("Message from %q rejected: %s: %s", u.Name(), code, detail)
+	u.Message(NewErrorMessage(code, detail))
+}
+
+// requiredState returns the state a message only makes sense in, if any.
+func requiredState(m Message) (GameState, bool) {
+	switch m.(type) {
+	case ReadyMessage:
+		return WaitingState, true
+	case SiteSelectionMessage:
+		return SiteSelectionState, true
+	case GoBeachMessage, EventResponseMessage, DefenseFailedMessage:
+		return SiteVisitState, true
+	case ShareFoodMessage:
+		return UpkeepState, true
+	}
+	return "", false
+}
+
 // RecieveMessage is called when a user sends a message to the server.
 func (g *Game) RecieveMessage(user User, message Message) {
+	if state, ok := requiredState(message); ok && state != g.state.Name() {
+		g.reject(user, InvalidStateError, "%T can only be sent during %s, not %s", message, state, g.state.Name())
+		return
+	}
+
 	switch msg := message.(type) {
 	case JoinMessage:
-		user.Message(NewWelcomeMessage(g.name, string(g.state.Name())))
+		if g.joined(user) {
+			// The client asks to join every time it connects, so
+			// someone who is already playing is just caught up.
+			g.RecieveMessage(user, NewResumeMessage(nil))
+			return
+		}
+		// Players are told apart by name, e.g. when trading, so
+		// everyone who keeps the default name is numbered.
+		user.SetName(g.uniqueName(user, user.Name()))
+		user.Message(NewWelcomeMessage(g.name, string(g.state.Name()), user.Session(), g.seed))
 		g.UserSites[user] = NoSiteSelected
+		g.users = append(g.users, user)
+		g.Health[user] = g.Config.MaxHealth
+		g.Presence[user] = PresenceConnected
+		g.SendInventory(user)
+		g.SendHealth(user)
+		g.SendSnapshot(user)
+	case ResumeMessage:
+		// Catch the user up. The state controller replays anything
+		// specific to the current state.
+		user.Message(NewWelcomeMessage(g.name, string(g.state.Name()), user.Session(), g.seed))
+		user.Message(NewGameStateChangedMessage(g.state.Name()))
+		g.SendInventory(user)
+		g.SendHealth(user)
+		g.SendSnapshot(user)
+	case RequestSnapshotMessage:
+		g.SendSnapshot(user)
+	case UseBandageMessage:
+		g.UseBandage(user)
 	case LeaveMessage:
+		g.cancelTradesFor(user)
+		g.removeBumps(user)
 		delete(g.UserSites, user)
+		for i, u := range g.users {
+			if u == user {
+				g.users = append(g.users[:i:i], g.users[i+1:]...)
+				break
+			}
+		}
+		delete(g.Inventories, user)
+		delete(g.Health, user)
+		delete(g.Statuses, user)
+		delete(g.Presence, user)
+		delete(g.yieldCarry, user)
+	case PresenceMessage:
+		g.Presence[user] = msg.Presence
+		// The waiting room sends its own update, along with who is
+		// ready.
+		if g.state.Name() != WaitingState {
+			g.connection.Broadcast(NewPlayerInfoUpdateMessage(g.PlayerInfo(nil)))
+		}
 	case SetNameMessage:
+		if g.nameTaken(user, msg.Name) {
+			g.reject(user, NameTakenError, "someone is already called %q", msg.Name)
+			return
+		}
 		user.SetName(msg.Name)
 	case DeathMessage:
-		user.SetAlive(false)
+		// Death is decided by the server, so the client's opinion is
+		// ignored.
+		log.Printf("Ignoring death message from %q", user.Name())
+	case TradeProposeMessage:
+		g.ProposeTrade(user, msg.To, msg.Offer, msg.Request)
+	case TradeCounterMessage:
+		g.CounterTrade(user, msg.TradeID, msg.Offer, msg.Request)
+	case TradeAcceptMessage:
+		g.AcceptTrade(user, msg.TradeID)
+	case TradeDeclineMessage:
+		g.DeclineTrade(user, msg.TradeID)
 	case TradeMessage:
-		isntSelfTrade := g.stagedUser != user
-		withinTimeInterval := g.GetTime()-g.stagingTime < TradeTimeout
-		log.Println("Trade proposed")
-		if isntSelfTrade && g.stagedUser != nil && withinTimeInterval {
-			log.Println("Trade accepted")
-			// Execute the currently proposed trade.
-			g.stagedUser.Message(NewTradeCompletedMessage(msg.Materials))
-			user.Message(NewTradeCompletedMessage(g.stagedMaterials))
-
-			// Reset the staged materials
-			g.stagedUser = nil
-			g.stagingTime = 0
-			g.stagedMaterials = ""
-		} else {
-			g.stagedUser = user
-			g.stagingTime = g.GetTime()
-			g.stagedMaterials = msg.Materials
-		}
+		g.AddBump(user, msg.Materials, msg.Token)
 	}
 	g.state.RecieveMessage(user, message)
 }
 
+// nameTaken returns true if anyone else in the game goes by the name.
+func (g *Game) nameTaken(u User, name string) bool {
+	for _, other := range g.users {
+		if other != u && other.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueName returns the name, numbered if someone else in the game already
+// goes by it.
+func (g *Game) uniqueName(u User, name string) string {
+	unique := name
+	for n := 2; g.nameTaken(u, unique); n++ {
+		unique = fmt.Sprintf("%s %d", name, n)
+	}
+	return unique
+}
+
+// executeTrade swaps the materials offered by two users. The materials are
+// the JSON encoded commodity counts sent by the client. The trade only goes
+// through if both users actually own what they are offering.
+func (g *Game) executeTrade(a User, aMaterials string, b User, bMaterials string) {
+	aOffer, err := decodeGoods(aMaterials)
+	if err != nil {
+		g.failTrade(a, b, MalformedPayloadError, "invalid trade materials: %v", err)
+		return
+	}
+	bOffer, err := decodeGoods(bMaterials)
+	if err != nil {
+		g.failTrade(b, a, MalformedPayloadError, "invalid trade materials: %v", err)
+		return
+	}
+
+	if !g.Inventory(a).Has(aOffer) {
+		g.failTrade(a, b, InsufficientResourcesError, "you don't own %v", aOffer)
+		return
+	}
+	if !g.Inventory(b).Has(bOffer) {
+		g.failTrade(b, a, InsufficientResourcesError, "you don't own %v", bOffer)
+		return
+	}
+
+	log.Println("Trade accepted")
+	g.Inventory(a).Remove(aOffer)
+	g.Inventory(b).Remove(bOffer)
+	g.Inventory(a).Add(bOffer)
+	g.Inventory(b).Add(aOffer)
+
+	a.Message(NewTradeCompletedMessage(bMaterials))
+	b.Message(NewTradeCompletedMessage(aMaterials))
+	g.SendInventory(a)
+	g.SendInventory(b)
+}
+
+// failTrade calls off a bump trade because of something the culprit did. They
+// are sent an error, and the other side is told the trade didn't happen.
+func (g *Game) failTrade(culprit, other User, code ErrorCode, format string, args ...interface{}) {
+	g.reject(culprit, code, format, args...)
+	other.Message(NewTradeClosedMessage(0, TradeInvalid, fmt.Sprintf("%s couldn't trade", culprit.Name())))
+	g.SendInventory(culprit)
+	g.SendInventory(other)
+}
+
+// Users returns every user in the game, in the order they joined. Iterate
+// over this rather than the maps keyed by user, so that the game plays out
+// the same way every time for the same seed.
+func (g *Game) Users() []User {
+	return g.users
+}
+
+// joined returns true if the user is already in the game.
+func (g *Game) joined(u User) bool {
+	for _, x := range g.users {
+		if x == u {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOver returns true once the game has reached an ending: either some
+// players escaped the island, or nobody is left alive.
+func (g *Game) IsOver() bool {
+	if len(g.Escaped) > 0 {
+		return true
+	}
+	if len(g.UserSites) == 0 {
+		return false
+	}
+	for u, _ := range g.UserSites {
+		if g.IsAlive(u) {
+			return false
+		}
+	}
+	return true
+}
+
 // ChangeState can be called by the state to transition to a new state.
 func (g *Game) ChangeState(newState GameState) {
+	next := NewStateController(g, newState)
+	if next == nil {
+		log.Printf("Unknown state %q, staying in %q", newState, g.state.Name())
+		return
+	}
 	g.state.End()
 
 	log.Printf("State changed from %q to %q", g.state.Name(), newState)
@@ -166,6 +408,6 @@ func (g *Game) ChangeState(newState GameState) {
 	g.nextTimeout = 0
 
 	g.connection.Broadcast(NewGameStateChangedMessage(newState))
-	g.state = NewStateController(g, newState)
+	g.state = next
 	g.state.Begin()
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// MinEventsPerVisit and MaxEventsPerVisit are the default bounds on how
+	// many random events each user gets during a site visit, as long as
+	// enough events are eligible.
+	MinEventsPerVisit int = 2
+	MaxEventsPerVisit int = 3
+)
+
+// Rarity scales how likely an event is, on top of its weight.
+type Rarity string
+
+const (
+	Common   Rarity = "common"
+	Uncommon Rarity = "uncommon"
+	Rare     Rarity = "rare"
+)
+
+// rarityScale is the percentage of its weight an event of each rarity keeps.
+var rarityScale = map[Rarity]int{
+	Common:   100,
+	Uncommon: 40,
+	Rare:     10,
+}
+
+// EventConditions restrict when an event can happen. Zero values place no
+// restriction.
+type EventConditions struct {
+	MinRepair int                   `json:"min_repair,omitempty"`
+	MaxRepair int                   `json:"max_repair,omitempty"`
+	MinHealth int                   `json:"min_health,omitempty"`
+	MaxHealth int                   `json:"max_health,omitempty"`
+	MinRound  int                   `json:"min_round,omitempty"`
+	MaxRound  int                   `json:"max_round,omitempty"`
+	Requires  map[CommodityType]int `json:"requires,omitempty"`
+}
+
+// Met returns true if the conditions hold for the user right now.
+func (c EventConditions) Met(g *Game, u User) bool {
+	repair := int(g.SiteRepairState[g.UserSites[u]])
+	health := g.Health[u]
+	switch {
+	case repair < c.MinRepair, c.MaxRepair != 0 && repair > c.MaxRepair:
+		return false
+	case health < c.MinHealth, c.MaxHealth != 0 && health > c.MaxHealth:
+		return false
+	case g.round < c.MinRound, c.MaxRound != 0 && g.round > c.MaxRound:
+		return false
+	}
+	return g.Inventory(u).Has(c.Requires)
+}
+
+// GenerationRules control how an event is picked, beyond its weight. The
+// cooldowns are the number of rounds before the event can happen again to
+// the same player, or to anyone in the game.
+type GenerationRules struct {
+	ID             string
+	Rarity         Rarity
+	Conditions     EventConditions
+	PlayerCooldown int
+	GameCooldown   int
+}
+
+// RuledEvent is implemented by events with generation rules. Events which
+// don't implement it are common, and always eligible.
+type RuledEvent interface {
+	Rules() GenerationRules
+}
+
+func rulesFor(e SiteEvent) GenerationRules {
+	if r, ok := e.(RuledEvent); ok {
+		rules := r.Rules()
+		if rules.Rarity == "" {
+			rules.Rarity = Common
+		}
+		return rules
+	}
+	return GenerationRules{ID: fmt.Sprintf("%T", e), Rarity: Common}
+}
+
+// EventGenerator picks the random events each user gets during a visit, and
+// remembers when each event last happened for its cooldowns.
+type EventGenerator struct {
+	game   *Game
+	events []SiteEvent
+
+	lastForPlayer map[string]map[User]int
+	lastForGame   map[string]int
+}
+
+// NewEventGenerator constructs a generator which picks from the given events.
+func NewEventGenerator(game *Game, events []SiteEvent) *EventGenerator {
+	return &EventGenerator{
+		game:          game,
+		events:        events,
+		lastForPlayer: map[string]map[User]int{},
+		lastForGame:   map[string]int{},
+	}
+}
+
+// coolingDown returns true if the event happened too recently.
+func (gen *EventGenerator) coolingDown(rules GenerationRules, u User) bool {
+	round := gen.game.round
+	if last, ok := gen.lastForGame[rules.ID]; ok && round-last < rules.GameCooldown {
+		return true
+	}
+	if last, ok := gen.lastForPlayer[rules.ID][u]; ok && round-last < rules.PlayerCooldown {
+		return true
+	}
+	return false
+}
+
+// weight returns how likely an event is to be picked for the user right now.
+// Zero means it can't happen.
+func (gen *EventGenerator) weight(e SiteEvent, u User) int {
+	rules := rulesFor(e)
+	if !rules.Conditions.Met(gen.game, u) || gen.coolingDown(rules, u) {
+		return 0
+	}
+	w := e.Mods(gen.game, u) * rarityScale[rules.Rarity] / 100
+	if w < 0 {
+		return 0
+	}
+	return w
+}
+
+// pick chooses one eligible event for the user, in proportion to the
+// weights. It returns nil if no event is eligible.
+func (gen *EventGenerator) pick(u User) SiteEvent {
+	weights := make([]int, len(gen.events))
+	total := 0
+	for i, e := range gen.events {
+		weights[i] = gen.weight(e, u)
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil
+	}
+
+	choice := gen.game.rng.Intn(total)
+	for i, w := range weights {
+		if choice < w {
+			return gen.events[i]
+		}
+		choice -= w
+	}
+	return nil
+}
+
+// record notes that an event happened to a user this round.
+func (gen *EventGenerator) record(e SiteEvent, u User) {
+	rules := rulesFor(e)
+	gen.lastForGame[rules.ID] = gen.game.round
+	if gen.lastForPlayer[rules.ID] == nil {
+		gen.lastForPlayer[rules.ID] = map[User]int{}
+	}
+	gen.lastForPlayer[rules.ID][u] = gen.game.round
+}
+
+// Generate picks between the configured minimum and maximum number of events
+// for a user. Fewer are returned only if not enough events are eligible.
+func (gen *EventGenerator) Generate(u User) []SiteEvent {
+	config := gen.game.Config
+	n := config.MinEventsPerVisit + gen.game.rng.Intn(config.MaxEventsPerVisit-config.MinEventsPerVisit+1)
+
+	events := []SiteEvent{}
+	for i := 0; i < n; i++ {
+		e := gen.pick(u)
+		if e == nil {
+			break
+		}
+		gen.record(e, u)
+		events = append(events, e)
+	}
+	return events
+}
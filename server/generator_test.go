@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+)
+
+// testEvent is a SiteEvent with fixed rules, for testing the generator.
+type testEvent struct {
+	RepairSite
+	rules  GenerationRules
+	weight int
+}
+
+func (e testEvent) Mods(g *Game, u User) int { return e.weight }
+func (e testEvent) Rules() GenerationRules   { return e.rules }
+
+func newTestEvent(id string, weight int) testEvent {
+	return testEvent{rules: GenerationRules{ID: id}, weight: weight}
+}
+
+func TestGenerateCount(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	gen := NewEventGenerator(g, []SiteEvent{newTestEvent("a", 1)})
+
+	for i := 0; i < 50; i++ {
+		n := len(gen.Generate(alice))
+		if n < MinEventsPerVisit || n > MaxEventsPerVisit {
+			t.Fatalf("generated %d events, want between %d and %d", n, MinEventsPerVisit, MaxEventsPerVisit)
+		}
+	}
+
+	gen = NewEventGenerator(g, []SiteEvent{newTestEvent("never", 0)})
+	if events := gen.Generate(alice); len(events) != 0 {
+		t.Errorf("generated %d events with no eligible events", len(events))
+	}
+}
+
+func TestGenerateConditions(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+
+	late := newTestEvent("late", 1)
+	late.rules.Conditions = EventConditions{MinRound: 2}
+	rich := newTestEvent("rich", 1)
+	rich.rules.Conditions = EventConditions{Requires: map[CommodityType]int{Bullet: 2}}
+	gen := NewEventGenerator(g, []SiteEvent{late, rich})
+
+	g.round = 1
+	g.Inventory(alice)[Bullet] = 1
+	if events := gen.Generate(alice); len(events) != 0 {
+		t.Errorf("generated %v, want nothing", events)
+	}
+
+	g.round = 2
+	for _, e := range gen.Generate(alice) {
+		if e.(testEvent).rules.ID != "late" {
+			t.Errorf("generated %q, want only late events", e.(testEvent).rules.ID)
+		}
+	}
+}
+
+func TestGenerateCooldowns(t *testing.T) {
+	alice := newFakeUser("alice")
+	bob := newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+
+	once := newTestEvent("once", 1)
+	once.rules.PlayerCooldown = 2
+	global := newTestEvent("global", 1)
+	global.rules.GameCooldown = 1
+	gen := NewEventGenerator(g, []SiteEvent{once, global})
+
+	g.round = 1
+	if events := gen.Generate(alice); len(events) != 2 {
+		t.Fatalf("generated %d events for alice, want one of each", len(events))
+	}
+	if events := gen.Generate(bob); len(events) != 1 || events[0].(testEvent).rules.ID != "once" {
+		t.Errorf("generated %v for bob, want only once", events)
+	}
+
+	g.round = 2
+	if events := gen.Generate(alice); len(events) != 1 || events[0].(testEvent).rules.ID != "global" {
+		t.Errorf("generated %v for alice, want only global", events)
+	}
+
+	g.round = 3
+	if events := gen.Generate(alice); len(events) != 2 {
+		t.Errorf("generated %d events for alice after the cooldowns, want 2", len(events))
+	}
+}
+
+func TestRarityScalesWeight(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+
+	rare := newTestEvent("rare", 100)
+	rare.rules.Rarity = Rare
+	gen := NewEventGenerator(g, nil)
+	if got := gen.weight(rare, alice); got != 10 {
+		t.Errorf("rare weight = %d, want 10", got)
+	}
+	if got := gen.weight(newTestEvent("common", 100), alice); got != 100 {
+		t.Errorf("common weight = %d, want 100", got)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	// DefaultMaxHealth is the health each player starts with, and the most
+	// health they can ever have.
+	DefaultMaxHealth int = 3
+
+	// BandageHealAmount is how much health a single bandage restores.
+	BandageHealAmount int = 1
+
+	// HospitalHealBonus is how much more a bandage heals at a fully
+	// repaired hospital.
+	HospitalHealBonus int = 1
+
+	// BleedDamage is how much health a bleeding player loses at the end
+	// of each day.
+	BleedDamage int = 1
+)
+
+// StatusEffect is something ailing a player until it is cured.
+type StatusEffect string
+
+const (
+	// Bleeding players lose health at the end of each day, until they
+	// use a bandage.
+	Bleeding StatusEffect = "bleeding"
+)
+
+// AllStatusEffects lists every status effect, in the order they are reported.
+var AllStatusEffects = []StatusEffect{Bleeding}
+
+// IsAlive returns true if the user still has some health left.
+func (g *Game) IsAlive(u User) bool {
+	return g.Health[u] > 0
+}
+
+// ModifyHealth changes a user's health by the given amount, capped at the
+// game's MaxHealth. If their health drops to zero, the user dies. Dead users
+// can't be healed.
+func (g *Game) ModifyHealth(u User, amount int) {
+	if amount == 0 || !g.IsAlive(u) {
+		return
+	}
+
+	health := g.Health[u] + amount
+	if health > g.Config.MaxHealth {
+		health = g.Config.MaxHealth
+	}
+	if health < 0 {
+		health = 0
+	}
+	g.Health[u] = health
+	g.SendHealth(u)
+
+	if health == 0 {
+		g.kill(u)
+	}
+}
+
+// SendHealth informs a user of their current health and status effects.
+func (g *Game) SendHealth(u User) {
+	u.Message(NewHealthUpdateMessage(g.Health[u], g.Config.MaxHealth, g.StatusEffects(u)))
+}
+
+// HasStatus returns true if the user is suffering from the status effect.
+func (g *Game) HasStatus(u User, e StatusEffect) bool {
+	return g.Statuses[u][e]
+}
+
+// AddStatus afflicts a living user with a status effect, and tells them.
+func (g *Game) AddStatus(u User, e StatusEffect) {
+	if g.HasStatus(u, e) || !g.IsAlive(u) {
+		return
+	}
+	if g.Statuses[u] == nil {
+		g.Statuses[u] = map[StatusEffect]bool{}
+	}
+	g.Statuses[u][e] = true
+	g.SendHealth(u)
+}
+
+// CureStatus rids a user of a status effect, and tells them.
+func (g *Game) CureStatus(u User, e StatusEffect) {
+	if !g.HasStatus(u, e) {
+		return
+	}
+	delete(g.Statuses[u], e)
+	g.SendHealth(u)
+}
+
+// StatusEffects lists the status effects the user is suffering from.
+func (g *Game) StatusEffects(u User) []StatusEffect {
+	statuses := []StatusEffect{}
+	for _, e := range AllStatusEffects {
+		if g.HasStatus(u, e) {
+			statuses = append(statuses, e)
+		}
+	}
+	return statuses
+}
+
+// NeedsTreatment returns true if a bandage would do the user any good.
+func (g *Game) NeedsTreatment(u User) bool {
+	return g.IsAlive(u) && (g.Health[u] < g.Config.MaxHealth || len(g.StatusEffects(u)) > 0)
+}
+
+// BandageHealing returns how much a bandage heals. Bandages go further at
+// the hospital, the better repaired it is.
+func (g *Game) BandageHealing(atHospital bool) int {
+	heal := BandageHealAmount
+	if atHospital {
+		heal += g.RepairBonus(Hospital, HospitalHealBonus)
+	}
+	return heal
+}
+
+// Bleed hurts everyone who is bleeding. It is called at the end of each day.
+func (g *Game) Bleed() {
+	for _, u := range g.Users() {
+		if g.IsAlive(u) && g.HasStatus(u, Bleeding) {
+			log.Printf("User[name=%v] is bleeding", u.Name())
+			g.ModifyHealth(u, -BleedDamage)
+		}
+	}
+}
+
+// UseBandage spends one of the user's bandages on themselves, outside of any
+// event. It stops them bleeding, and heals them.
+func (g *Game) UseBandage(u User) {
+	switch {
+	case g.state.Name() == WaitingState || g.state.Name() == GameOverState:
+		g.reject(u, InvalidStateError, "there's no game in progress")
+		return
+	case !g.NeedsTreatment(u):
+		g.reject(u, InvalidStateError, "you don't need a bandage")
+		return
+	case !g.Inventory(u).Remove(map[CommodityType]int{Bandage: 1}):
+		g.reject(u, InsufficientResourcesError, "you don't have a bandage")
+		g.SendInventory(u)
+		return
+	}
+	g.SendInventory(u)
+
+	atHospital := g.state.Name() == SiteVisitState && g.UserSites[u] == Hospital
+	g.CureStatus(u, Bleeding)
+	g.ModifyHealth(u, g.BandageHealing(atHospital))
+}
+
+// kill marks a user as dead and lets everyone know about it.
+func (g *Game) kill(u User) {
+	log.Printf("User[name=%v] died", u.Name())
+	g.Health[u] = 0
+	delete(g.Statuses, u)
+	u.SetAlive(false)
+	g.connection.Broadcast(NewPlayerDiedMessage(u.Name()))
+}
+
+type TreatWounds struct{}
+
+func NewTreatWounds() TreatWounds {
+	return TreatWounds{}
+}
+
+func (e TreatWounds) Mods(g *Game, u User) int { return 0 }
+func (e TreatWounds) Begin(g *Game, u User) EventMessage {
+	title := "You're hurt"
+	description := "You could use a bandage to patch up your wounds."
+	if g.HasStatus(u, Bleeding) {
+		title = "You're bleeding"
+		description = "You could use a bandage to stop the bleeding."
+	}
+	msg := NewEventMessage(title, description)
+	msg.WithOKButton("Ignore it")
+	msg.WithActionButton("Use bandage", Bandage, 1)
+	return msg
+}
+func (e TreatWounds) End(g *Game, u User, r EventResponseMessage) *EventMessage {
+	if !r.ClickedAction {
+		return nil
+	}
+
+	g.CureStatus(u, Bleeding)
+	msg := NewEventMessage("You bandaged your wounds.", "You feel a little better.")
+	msg.HealthModifier = g.BandageHealing(g.UserSites[u] == Hospital)
+	if msg.HealthModifier > BandageHealAmount {
+		msg.Description = "The hospital has everything you need. You feel much better."
+	}
+	return &msg
+}
+
+// HospitalTreatment lets a user at the hospital use a bandage on themselves,
+// or on anyone else there who needs it.
+type HospitalTreatment struct{}
+
+func NewHospitalTreatment() HospitalTreatment {
+	return HospitalTreatment{}
+}
+
+func (e HospitalTreatment) Rules() GenerationRules {
+	return GenerationRules{ID: "hospital_treatment", Rarity: Common}
+}
+
+// Mods only offers treatment when somebody at the hospital needs it.
+func (e HospitalTreatment) Mods(g *Game, u User) int {
+	if g.UserSites[u] != Hospital || len(e.patients(g, u)) == 0 {
+		return 0
+	}
+	return 200
+}
+
+// patients returns everyone at the hospital who needs treatment, starting
+// with the user.
+func (e HospitalTreatment) patients(g *Game, u User) []User {
+	var patients []User
+	if g.NeedsTreatment(u) {
+		patients = append(patients, u)
+	}
+	for _, other := range g.Users() {
+		if other != u && g.UserSites[other] == Hospital && g.NeedsTreatment(other) {
+			patients = append(patients, other)
+		}
+	}
+	return patients
+}
+
+func (e HospitalTreatment) Begin(g *Game, u User) EventMessage {
+	patients := e.patients(g, u)
+	if len(patients) == 0 {
+		return NewEventMessage("The hospital is quiet", "Nobody here needs patching up.")
+	}
+
+	msg := NewEventMessage("Patients are waiting", "You could use a bandage on yourself, or on anyone else here who is hurt.")
+	msg.WithOKButton("Not now")
+	msg.WithActionButton("Use bandage", Bandage, 1)
+	for _, p := range patients {
+		msg.Targets = append(msg.Targets, p.Name())
+	}
+	return msg
+}
+
+// target returns the patient the user chose to treat. If they didn't choose
+// anyone, they treat themselves.
+func (e HospitalTreatment) target(g *Game, u User, name string) (User, error) {
+	if name == "" {
+		return u, nil
+	}
+	var found User
+	for _, p := range e.patients(g, u) {
+		if p.Name() != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("more than one patient is called %q", name)
+		}
+		found = p
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no patient called %q", name)
+	}
+	return found, nil
+}
+
+// CheckResponse turns away treatment for anyone who isn't a patient, before
+// the bandage is spent.
+func (e HospitalTreatment) CheckResponse(g *Game, u User, r EventResponseMessage) error {
+	if !r.ClickedAction {
+		return nil
+	}
+	_, err := e.target(g, u, r.Target)
+	return err
+}
+
+func (e HospitalTreatment) End(g *Game, u User, r EventResponseMessage) *EventMessage {
+	if !r.ClickedAction {
+		return nil
+	}
+	target, err := e.target(g, u, r.Target)
+	if err != nil {
+		// CheckResponse has already turned the response away.
+		return nil
+	}
+
+	heal := g.BandageHealing(true)
+	g.CureStatus(target, Bleeding)
+	if target == u {
+		msg := NewEventMessage("You bandaged your wounds.", "You feel better.")
+		msg.HealthModifier = heal
+		return &msg
+	}
+
+	g.ModifyHealth(target, heal)
+	msg := NewEventMessage(fmt.Sprintf("You patched up %s.", target.Name()), "They look better already.")
+	return &msg
+}
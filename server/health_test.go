@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnstoppedAttackCausesBleeding(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	s := startVisit(t, g, alice, Forest)
+
+	nextEvent(t, s, c, alice, NewAttack())
+	c.Advance(SiteVisitRoundDuration)
+	if !g.HasStatus(alice, Bleeding) {
+		t.Fatalf("alice isn't bleeding after being bitten")
+	}
+
+	health := g.Health[alice]
+	g.Bleed()
+	if got, want := g.Health[alice], health-BleedDamage; got != want {
+		t.Errorf("health = %d after bleeding, want %d", got, want)
+	}
+}
+
+func TestUseBandage(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	startVisit(t, g, alice, Forest)
+
+	g.RecieveMessage(alice, NewUseBandageMessage())
+	if got := alice.lastError(); got != InvalidStateError {
+		t.Errorf("error = %q using a bandage while healthy, want %q", got, InvalidStateError)
+	}
+
+	g.Health[alice] = 1
+	g.AddStatus(alice, Bleeding)
+	g.RecieveMessage(alice, NewUseBandageMessage())
+	if got := alice.lastError(); got != InsufficientResourcesError {
+		t.Errorf("error = %q using a bandage without one, want %q", got, InsufficientResourcesError)
+	}
+
+	g.Inventory(alice)[Bandage] = 1
+	g.RecieveMessage(alice, NewUseBandageMessage())
+	if g.HasStatus(alice, Bleeding) {
+		t.Errorf("alice is still bleeding after using a bandage")
+	}
+	if got, want := g.Health[alice], 1+BandageHealAmount; got != want {
+		t.Errorf("health = %d, want %d", got, want)
+	}
+	if got := g.Inventory(alice)[Bandage]; got != 0 {
+		t.Errorf("bandages = %d, want 0", got)
+	}
+}
+
+func TestHospitalTreatsOthers(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Bandage] = 1
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Hospital))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Hospital))
+	s := g.state.(*SiteVisitController)
+
+	g.SiteRepairState[Hospital] = MaxRepairState
+	g.Health[bob] = 1
+	g.AddStatus(bob, Bleeding)
+	event := nextEvent(t, s, c, alice, NewHospitalTreatment())
+	if len(event.Targets) != 1 || event.Targets[0] != "bob" {
+		t.Fatalf("targets = %v, want bob", event.Targets)
+	}
+
+	response := NewEventResponseMessage(event.MessageID, false, true, 0)
+	response.Target = "bob"
+	g.RecieveMessage(alice, response)
+	if g.HasStatus(bob, Bleeding) {
+		t.Errorf("bob is still bleeding after being treated")
+	}
+	if got, want := g.Health[bob], 1+BandageHealAmount+HospitalHealBonus; got != want {
+		t.Errorf("bob's health = %d, want %d from a fully repaired hospital", got, want)
+	}
+	if got := g.Inventory(alice)[Bandage]; got != 0 {
+		t.Errorf("alice's bandages = %d, want 0", got)
+	}
+}
+
+func TestHospitalRejectsUnknownPatient(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Bandage] = 1
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Hospital))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Hospital))
+	s := g.state.(*SiteVisitController)
+
+	g.Health[bob] = 1
+	event := nextEvent(t, s, c, alice, NewHospitalTreatment())
+	response := NewEventResponseMessage(event.MessageID, false, true, 0)
+	response.Target = "carol"
+	g.RecieveMessage(alice, response)
+
+	if got := alice.lastError(); got != MalformedPayloadError {
+		t.Errorf("error = %q, want %q", got, MalformedPayloadError)
+	}
+	if got := g.Inventory(alice)[Bandage]; got != 1 {
+		t.Errorf("alice's bandages = %d, want the bandage kept", got)
+	}
+	if _, ok := s.ActiveEvent(alice); !ok {
+		t.Errorf("event was closed, want alice to be able to choose again")
+	}
+
+	response.Target = "bob"
+	g.RecieveMessage(alice, response)
+	if got := g.Health[bob]; got <= 1 {
+		t.Errorf("bob's health = %d, want bob treated", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+)
+
+// Inventory counts how many of each commodity a player is holding.
+type Inventory map[CommodityType]int
+
+// NewInventory constructs an empty inventory.
+func NewInventory() Inventory {
+	inv := Inventory{}
+	for _, c := range AllCommodities {
+		inv[c] = 0
+	}
+	return inv
+}
+
+// Has returns true if the inventory contains at least the given items. Negative
+// amounts are never satisfiable.
+func (inv Inventory) Has(items map[CommodityType]int) bool {
+	for c, n := range items {
+		if n < 0 || inv[c] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// Add puts the given items into the inventory.
+func (inv Inventory) Add(items map[CommodityType]int) {
+	for c, n := range items {
+		inv[c] += n
+	}
+}
+
+// Remove takes the given items out of the inventory. If the inventory doesn't
+// hold enough, nothing is removed and false is returned.
+func (inv Inventory) Remove(items map[CommodityType]int) bool {
+	if !inv.Has(items) {
+		return false
+	}
+	for c, n := range items {
+		inv[c] -= n
+	}
+	return true
+}
+
+// Copy returns an independent copy of the inventory.
+func (inv Inventory) Copy() Inventory {
+	c := Inventory{}
+	for k, v := range inv {
+		c[k] = v
+	}
+	return c
+}
+
+// Inventory returns the inventory of a user, creating it if necessary.
+func (g *Game) Inventory(u User) Inventory {
+	inv, ok := g.Inventories[u]
+	if !ok {
+		inv = NewInventory()
+		g.Inventories[u] = inv
+	}
+	return inv
+}
+
+// Credit gives a user some of a commodity and informs them of their new
+// inventory.
+func (g *Game) Credit(u User, c CommodityType, amount int) {
+	if amount <= 0 {
+		return
+	}
+	g.Inventory(u).Add(map[CommodityType]int{c: amount})
+	g.SendInventory(u)
+}
+
+// Debit takes some of a commodity away from a user. It returns false, and
+// leaves the inventory untouched, if the user doesn't own enough.
+func (g *Game) Debit(u User, c CommodityType, amount int) bool {
+	if amount <= 0 {
+		return amount == 0
+	}
+	if !g.Inventory(u).Remove(map[CommodityType]int{c: amount}) {
+		log.Printf("User[name=%v] tried to spend %d %s without owning it", u.Name(), amount, c)
+		return false
+	}
+	g.SendInventory(u)
+	return true
+}
+
+// SendInventory sends a user a snapshot of what the server thinks they own.
+func (g *Game) SendInventory(u User) {
+	u.Message(NewInventoryUpdateMessage(g.Inventory(u).Copy()))
+}
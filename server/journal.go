@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Kinds of journal records.
+const (
+	// GameRecord is the first record in every journal, and holds the name
+	// and seed of the game.
+	GameRecord string = "game"
+	// InboundRecord is a message delivered to the game from a user.
+	InboundRecord string = "in"
+	// TickRecord is a tick of the game clock.
+	TickRecord string = "tick"
+	// OutboundRecord is a message the game sent to a single user.
+	OutboundRecord string = "out"
+	// BroadcastRecord is a message the game sent to every user.
+	BroadcastRecord string = "broadcast"
+)
+
+// A JournalRecord is a single line in a game's journal. Players are
+// identified by a number which is only meaningful within the journal, rather
+// than their session, since the session would let anyone who can read the
+// journal take over their place.
+type JournalRecord struct {
+	Seq     int             `json:"seq"`
+	Kind    string          `json:"kind"`
+	Game    string          `json:"game,omitempty"`
+	Seed    int64           `json:"seed,omitempty"`
+	Config  *GameConfig     `json:"config,omitempty"`
+	Player  int             `json:"player,omitempty"`
+	Name    string          `json:"name,omitempty"`
+	Tick    int64           `json:"tick_ms,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+// A Journal is an append-only log of everything that goes in and out of a
+// game. Together with the game's seed, it is enough to replay the game
+// exactly. A nil Journal records nothing.
+type Journal struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	closer  io.Closer
+	path    string
+	closed  bool
+	seq     int
+	players map[User]int
+}
+
+// NewJournal constructs a journal which writes to w.
+func NewJournal(w io.Writer) *Journal {
+	j := &Journal{enc: json.NewEncoder(w), players: map[User]int{}}
+	if c, ok := w.(io.Closer); ok {
+		j.closer = c
+	}
+	return j
+}
+
+// OpenJournal creates a new journal file for a game in dir. Games are never
+// journaled to the same file, even if a game with the same name and seed was
+// played before.
+func OpenJournal(dir, game string, seed int64, config GameConfig) (*Journal, error) {
+	if err := checkGameName(game); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	var path string
+	for n := 1; f == nil; n++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.jsonl", game, seed))
+		if n > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d-%d.jsonl", game, seed, n))
+		}
+		var err error
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Journaling game %q to %s", game, path)
+
+	j := NewJournal(f)
+	j.path = path
+	j.write(JournalRecord{Kind: GameRecord, Game: game, Seed: seed, Config: &config})
+	return j, nil
+}
+
+func (j *Journal) write(r JournalRecord) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return
+	}
+
+	j.seq++
+	r.Seq = j.seq
+	if err := j.enc.Encode(r); err != nil {
+		log.Printf("Unable to write journal record: %v", err)
+	}
+}
+
+func (j *Journal) writeMessage(kind string, u User, message Message) {
+	if j == nil {
+		return
+	}
+	data, err := json.Marshal(journaledMessage(message))
+	if err != nil {
+		log.Printf("Unable to journal message %v: %v", message, err)
+		return
+	}
+	r := JournalRecord{Kind: kind, Message: data}
+	if u != nil {
+		r.Player = j.player(u)
+		r.Name = u.Name()
+	}
+	j.write(r)
+}
+
+// player returns the number the user is known by in the journal, numbering
+// them in the order they first appear.
+func (j *Journal) player(u User) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	id, ok := j.players[u]
+	if !ok {
+		id = len(j.players) + 1
+		j.players[u] = id
+	}
+	return id
+}
+
+// journaledMessage returns the message as it is written to the journal, with
+// any session token removed.
+func journaledMessage(message Message) Message {
+	if welcome, ok := message.(WelcomeMessage); ok {
+		welcome.Session = ""
+		return welcome
+	}
+	return message
+}
+
+// Inbound records a message delivered to the game from a user.
+func (j *Journal) Inbound(u User, message Message) {
+	j.writeMessage(InboundRecord, u, message)
+}
+
+// Outbound records a message sent by the game to a single user.
+func (j *Journal) Outbound(u User, message Message) {
+	j.writeMessage(OutboundRecord, u, message)
+}
+
+// Broadcast records a message sent by the game to every user.
+func (j *Journal) Broadcast(message Message) {
+	j.writeMessage(BroadcastRecord, nil, message)
+}
+
+// Tick records a tick of the game clock.
+func (j *Journal) Tick(tick time.Duration) {
+	j.write(JournalRecord{Kind: TickRecord, Tick: int64(tick / time.Millisecond)})
+}
+
+// Close closes the underlying file, if any.
+func (j *Journal) Close() {
+	if j == nil || j.closer == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.closed {
+		j.closed = true
+		j.closer.Close()
+	}
+}
+
+// Discard closes the journal and deletes its file, for a game that never
+// went ahead.
+func (j *Journal) Discard() {
+	if j == nil {
+		return
+	}
+	j.Close()
+	if j.path != "" {
+		if err := os.Remove(j.path); err != nil {
+			log.Printf("Unable to remove journal %s: %v", j.path, err)
+		}
+	}
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 )
 
 var (
-	// AllGames is a map of all the games currently in progress.
-	// The key is the name of the game.
-	AllGames map[string]*GameServer
+	// AllGames holds all the games currently in progress.
+	AllGames *GameRegistry
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,11 +23,75 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-// The /join URL takes two parameters, game, and name. The game
-// argument is optional. If specified, we'll try to join a game
-// with that name.
+// parseSeed reads the optional seed parameter, picking a random seed if it
+// isn't specified.
+func parseSeed(params url.Values) (int64, error) {
+	s := params.Get("seed")
+	if s == "" {
+		return NewSeed(), nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// The /create URL starts a new game and responds with its name and config
+// as JSON. The game parameter is optional. If specified, that name is
+// reserved for the game, otherwise a join code is generated. The seed
+// parameter is also optional, and makes the game play out the same way as an
+// earlier game with that seed. Any of the rules in GameConfig can also be
+// given, see ParseGameConfig.
+func create(w http.ResponseWriter, r *http.Request) {
+	seed, err := parseSeed(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid seed: %v", err), http.StatusBadRequest)
+		return
+	}
+	config, err := ParseGameConfig(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid config: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var game *GameServer
+	if name := r.URL.Query().Get("game"); name != "" {
+		game, err = AllGames.Reserve(name, seed, config)
+		if err == ErrGameNameTaken {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		game = AllGames.CreateUnique(seed, config)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"game":   game.game.name,
+		"config": game.game.Config,
+	})
+}
+
+// The /join URL takes three parameters, game, name and session. The
+// game argument is optional. If specified, we'll try to join a game
+// with that name, otherwise a new game is created with a fresh
+// join code. The session argument is the token from the welcome
+// message, and resumes a player's place in a game after their
+// connection dropped. The optional seed argument, and any rules from
+// GameConfig, are used if a new game is created.
 func join(w http.ResponseWriter, r *http.Request) {
 	params := r.URL.Query()
+	seed, err := parseSeed(params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid seed: %v", err), http.StatusBadRequest)
+		return
+	}
+	config, err := ParseGameConfig(params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid config: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	n, ok := params["name"]
 	name := "Anonymous"
 	if ok {
@@ -35,8 +102,12 @@ func join(w http.ResponseWriter, r *http.Request) {
 	var target string
 	if ok {
 		target = t[0]
-	} else {
-		target = GenerateGameName()
+	}
+	if target != "" {
+		if err := checkGameName(target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -47,24 +118,74 @@ func join(w http.ResponseWriter, r *http.Request) {
 
 	player := Player{
 		name:       name,
+		session:    NewSessionToken(),
 		Connection: conn,
 		alive:      true,
+		connected:  true,
+	}
+
+	if session := params.Get("session"); session != "" && target != "" {
+		if game, ok := AllGames.Get(target); ok {
+			player.session = session
+			if game.ResumePlayer(player) {
+				return
+			}
+			player.session = NewSessionToken()
+		}
 	}
 
-	game, ok := AllGames[target]
-	if !ok {
-		// The game doesn't exist, create it.
-		game = NewGameServer(target)
-		AllGames[target] = game
+	// The game might finish between looking it up and joining it, in which
+	// case a fresh game is created under the same name.
+	for {
+		var game *GameServer
+		if target == "" {
+			game = AllGames.CreateUnique(seed, config)
+		} else {
+			game, err = AllGames.GetOrCreate(target, seed, config)
+			if err != nil {
+				log.Println(err)
+				conn.Close()
+				return
+			}
+		}
+		if game.AddPlayer(player) {
+			return
+		}
 	}
-	game.AddPlayer(player)
 }
 
 func main() {
 	port := flag.String("port", "8080", "the port to use to serve")
+	flag.StringVar(&JournalDir, "journal-dir", "journals", "the directory to write game journals to, or empty to disable them")
+	events := flag.String("events", "events", "the directory to load event definitions from")
+	flag.StringVar((*string)(&OutboxOverflow), "outbox-overflow", string(DisconnectOnOverflow), "what to do when a player can't keep up with their messages: drop them, or disconnect")
+	flag.BoolVar(&DebugActions, "debug", false, "let players send debug actions, e.g. to advance the game clock")
+	replay := flag.String("replay", "", "replay a game journal and check it produces the same messages, instead of serving")
 	flag.Parse()
 
-	AllGames = make(map[string]*GameServer)
+	if OutboxOverflow != DropOnOverflow && OutboxOverflow != DisconnectOnOverflow {
+		log.Fatalf("Unknown outbox overflow policy %q", OutboxOverflow)
+	}
+
+	defined, err := LoadEventDefinitions(*events)
+	if err != nil {
+		log.Fatalf("Unable to load event definitions: %v", err)
+	}
+	RandomEvents = append(RandomEvents, defined...)
+
+	// Replays need the same event definitions as the original game.
+	if *replay != "" {
+		if err := ReplayFile(*replay); err != nil {
+			log.Fatalf("Replay of %s failed: %v", *replay, err)
+		}
+		log.Printf("Replay of %s matched", *replay)
+		os.Exit(0)
+	}
+
+	AllGames = NewGameRegistry()
+	go AllGames.RunReaper()
+
+	http.HandleFunc("/create", create)
 	http.HandleFunc("/join", join)
 	http.HandleFunc("/", http.FileServer(http.Dir("./web")).ServeHTTP)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", *port), nil))
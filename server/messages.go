@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // MessageAction is the string used in the `action` field
@@ -18,26 +20,137 @@ const (
 	SetClockAction         MessageAction = "set_clock"
 	PlayerInfoUpdateAction MessageAction = "player_info_updated"
 	EventAction            MessageAction = "event"
+	PlayerDiedAction       MessageAction = "player_died"
+	GameOverAction         MessageAction = "game_over"
 
 	// Server-to-client messages
-	TradeCompletedAction MessageAction = "trade_completed"
+	TradeCompletedAction  MessageAction = "trade_completed"
+	TradeOfferedAction    MessageAction = "trade_offered"
+	TradeClosedAction     MessageAction = "trade_closed"
+	InventoryUpdateAction MessageAction = "inventory_updated"
+	HealthUpdateAction    MessageAction = "health_updated"
+	ErrorAction           MessageAction = "error"
+	StateSnapshotAction   MessageAction = "state_snapshot"
+	RepairUpdateAction    MessageAction = "repair_updated"
+	UpkeepAction          MessageAction = "upkeep"
+	DefenseSummaryAction  MessageAction = "defense_summary"
 
 	// Client messages
-	ReadyAction         MessageAction = "ready"
-	JoinAction          MessageAction = "join_game"
-	LeaveAction         MessageAction = "leave"
-	DeathAction         MessageAction = "death"
-	TradeAction         MessageAction = "trade"
-	SetNameAction       MessageAction = "set_name"
-	SiteSelectionAction MessageAction = "site_selected"
-	GoBeachAction       MessageAction = "go_beach"
-	EventResponseAction MessageAction = "event_response"
+	ReadyAction           MessageAction = "ready"
+	JoinAction            MessageAction = "join_game"
+	LeaveAction           MessageAction = "leave"
+	DeathAction           MessageAction = "death"
+	TradeAction           MessageAction = "trade"
+	TradeProposeAction    MessageAction = "trade_propose"
+	TradeCounterAction    MessageAction = "trade_counter"
+	TradeAcceptAction     MessageAction = "trade_accept"
+	TradeDeclineAction    MessageAction = "trade_decline"
+	SetNameAction         MessageAction = "set_name"
+	SiteSelectionAction   MessageAction = "site_selected"
+	GoBeachAction         MessageAction = "go_beach"
+	EventResponseAction   MessageAction = "event_response"
+	RequestSnapshotAction MessageAction = "request_snapshot"
+	ShareFoodAction       MessageAction = "share_food"
+	UseBandageAction      MessageAction = "use_bandage"
 
 	// Special debug-only actions
 	TickAction          MessageAction = "tick"
 	DefenseFailedAction MessageAction = "defense_failed"
+
+	// Internal-only actions
+	ResumeAction     MessageAction = "resume"
+	DisconnectAction MessageAction = "disconnect"
+	PresenceAction   MessageAction = "presence"
+)
+
+// MessageOrigin is where messages with an action come from.
+type MessageOrigin int
+
+const (
+	// ClientOrigin messages are sent by players.
+	ClientOrigin MessageOrigin = iota
+	// ServerOrigin messages are sent to players by the server.
+	ServerOrigin
+	// DebugOrigin messages are generated by the server, but players can
+	// send them too while developing.
+	DebugOrigin
+	// InternalOrigin messages are generated by the server for itself.
+	InternalOrigin
+)
+
+// actionOrigins says where each action comes from.
+var actionOrigins = map[string]MessageOrigin{
+	string(GameStateChangedAction): ServerOrigin,
+	string(WelcomeAction):          ServerOrigin,
+	string(SetClockAction):         ServerOrigin,
+	string(PlayerInfoUpdateAction): ServerOrigin,
+	string(EventAction):            ServerOrigin,
+	string(PlayerDiedAction):       ServerOrigin,
+	string(GameOverAction):         ServerOrigin,
+	string(TradeCompletedAction):   ServerOrigin,
+	string(TradeOfferedAction):     ServerOrigin,
+	string(TradeClosedAction):      ServerOrigin,
+	string(InventoryUpdateAction):  ServerOrigin,
+	string(HealthUpdateAction):     ServerOrigin,
+	string(ErrorAction):            ServerOrigin,
+	string(StateSnapshotAction):    ServerOrigin,
+	string(RepairUpdateAction):     ServerOrigin,
+	string(UpkeepAction):           ServerOrigin,
+	string(DefenseSummaryAction):   ServerOrigin,
+	string(ReadyAction):            ClientOrigin,
+	string(JoinAction):             ClientOrigin,
+	string(LeaveAction):            ClientOrigin,
+	string(DeathAction):            ClientOrigin,
+	string(TradeAction):            ClientOrigin,
+	string(TradeProposeAction):     ClientOrigin,
+	string(TradeCounterAction):     ClientOrigin,
+	string(TradeAcceptAction):      ClientOrigin,
+	string(TradeDeclineAction):     ClientOrigin,
+	string(SetNameAction):          ClientOrigin,
+	string(SiteSelectionAction):    ClientOrigin,
+	string(GoBeachAction):          ClientOrigin,
+	string(EventResponseAction):    ClientOrigin,
+	string(RequestSnapshotAction):  ClientOrigin,
+	string(ShareFoodAction):        ClientOrigin,
+	string(UseBandageAction):       ClientOrigin,
+	string(TickAction):             DebugOrigin,
+	string(DefenseFailedAction):    DebugOrigin,
+	string(ResumeAction):           InternalOrigin,
+	string(DisconnectAction):       InternalOrigin,
+	string(PresenceAction):         InternalOrigin,
+}
+
+// A Role is who messages are being decoded for, which decides the origins
+// they may have.
+type Role []MessageOrigin
+
+var (
+	// PlayerRole decodes messages sent by players.
+	PlayerRole = Role{ClientOrigin}
+	// DebugPlayerRole decodes messages sent by players while debug
+	// actions are enabled.
+	DebugPlayerRole = Role{ClientOrigin, DebugOrigin}
+	// ServerRole decodes messages sent by the server, as a player would.
+	ServerRole = Role{ServerOrigin}
+	// JournalRole decodes everything that can be delivered to a game,
+	// which is what a journal's inbound records hold.
+	JournalRole = Role{ClientOrigin, DebugOrigin, InternalOrigin}
 )
 
+// allows returns true if messages with the action may be decoded in the role.
+func (r Role) allows(action string) bool {
+	origin, ok := actionOrigins[action]
+	if !ok {
+		return false
+	}
+	for _, o := range r {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
 // A Message is an object which must contain an Action string, serializable
 // to the MessageAction, and may also contain other JSON serializable fields.
 type Message interface {
@@ -54,8 +167,9 @@ type BasicMessage struct {
 
 func (m BasicMessage) requiresAlive() bool { return false }
 
-// TickMessage is sent to increment the current game clock. Users shouldn't send
-// this message, it is only generated internally.
+// TickMessage is sent to increment the current game clock. Users can only
+// send this message while debug actions are enabled, otherwise it is only
+// generated internally.
 type TickMessage struct {
 	Action string  `json:"action"`
 	Tick   float64 `json:"tick_ms"`
@@ -101,8 +215,9 @@ func NewSetClockMessage(t time.Duration) Message {
 func (m SetClockMessage) requiresAlive() bool { return false }
 
 type PlayerInfo struct {
-	Name  string `json:"name"`
-	Ready bool   `json:"ready"`
+	Name     string   `json:"name"`
+	Ready    bool     `json:"ready"`
+	Presence Presence `json:"presence"`
 }
 
 type PlayerInfoUpdateMessage struct {
@@ -119,6 +234,41 @@ func NewPlayerInfoUpdateMessage(info []PlayerInfo) Message {
 
 func (m PlayerInfoUpdateMessage) requiresAlive() bool { return false }
 
+// PlayerDiedMessage is broadcast when the server decides a player has died.
+type PlayerDiedMessage struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+}
+
+func NewPlayerDiedMessage(name string) Message {
+	return PlayerDiedMessage{
+		Action: string(PlayerDiedAction),
+		Name:   name,
+	}
+}
+
+func (m PlayerDiedMessage) requiresAlive() bool { return false }
+
+// GameOverMessage is broadcast once the game has ended. The winner is either
+// "players" or "island", and survivors lists the players who escaped.
+type GameOverMessage struct {
+	Action    string   `json:"action"`
+	Winner    string   `json:"winner"`
+	Outcome   string   `json:"outcome"`
+	Survivors []string `json:"survivors"`
+}
+
+func NewGameOverMessage(winner, outcome string, survivors []string) Message {
+	return GameOverMessage{
+		Action:    string(GameOverAction),
+		Winner:    winner,
+		Outcome:   outcome,
+		Survivors: survivors,
+	}
+}
+
+func (m GameOverMessage) requiresAlive() bool { return false }
+
 type EventMessage struct {
 	Action       string `json:"action"`
 	MessageID    uint64 `json:"message_id"`
@@ -144,6 +294,10 @@ type EventMessage struct {
 	// Spend button: user decides how much to spend
 	HasSpendButton      bool          `json:"has_spend_button"`
 	SpendButtonResource CommodityType `json:"spend_button_resource"`
+
+	// If set, the user picks one of these players for the action, and
+	// sends their name back as the response's target.
+	Targets []string `json:"targets,omitempty"`
 }
 
 func NewEventMessage(title, description string) EventMessage {
@@ -194,22 +348,248 @@ func NewTradeCompletedMessage(materials string) Message {
 
 func (m TradeCompletedMessage) requiresAlive() bool { return true }
 
-type WelcomeMessage struct {
+// TradeOfferedMessage is sent to both parties when a trade is proposed.
+type TradeOfferedMessage struct {
+	Action    string                `json:"action"`
+	TradeID   uint64                `json:"trade_id"`
+	From      string                `json:"from"`
+	To        string                `json:"to"`
+	Offer     map[CommodityType]int `json:"offer"`
+	Request   map[CommodityType]int `json:"request"`
+	ExpiresIn int                   `json:"expires_in"`
+}
+
+func NewTradeOfferedMessage(t *Trade, expiresIn time.Duration) Message {
+	return TradeOfferedMessage{
+		Action:    string(TradeOfferedAction),
+		TradeID:   t.ID,
+		From:      t.From.Name(),
+		To:        t.To.Name(),
+		Offer:     t.Offer,
+		Request:   t.Request,
+		ExpiresIn: int(expiresIn / time.Millisecond),
+	}
+}
+
+func (m TradeOfferedMessage) requiresAlive() bool { return true }
+
+// TradeClosedMessage is sent when a trade is no longer open, or when a trade
+// request was rejected. The reason is one of the Trade* reasons.
+type TradeClosedMessage struct {
+	Action  string `json:"action"`
+	TradeID uint64 `json:"trade_id"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func NewTradeClosedMessage(id uint64, reason, detail string) Message {
+	return TradeClosedMessage{
+		Action:  string(TradeClosedAction),
+		TradeID: id,
+		Reason:  reason,
+		Detail:  detail,
+	}
+}
+
+func (m TradeClosedMessage) requiresAlive() bool { return false }
+
+// InventoryUpdateMessage tells a user what the server thinks they own. The
+// client should treat this as authoritative.
+type InventoryUpdateMessage struct {
+	Action    string                `json:"action"`
+	Inventory map[CommodityType]int `json:"inventory"`
+}
+
+func NewInventoryUpdateMessage(inventory map[CommodityType]int) Message {
+	return InventoryUpdateMessage{
+		Action:    string(InventoryUpdateAction),
+		Inventory: inventory,
+	}
+}
+
+func (m InventoryUpdateMessage) requiresAlive() bool { return false }
+
+// HealthUpdateMessage tells a user their current health, and any status
+// effects they are suffering from.
+type HealthUpdateMessage struct {
+	Action    string         `json:"action"`
+	Health    int            `json:"health"`
+	MaxHealth int            `json:"max_health"`
+	Statuses  []StatusEffect `json:"statuses"`
+}
+
+func NewHealthUpdateMessage(health, maxHealth int, statuses []StatusEffect) Message {
+	return HealthUpdateMessage{
+		Action:    string(HealthUpdateAction),
+		Health:    health,
+		MaxHealth: maxHealth,
+		Statuses:  statuses,
+	}
+}
+
+func (m HealthUpdateMessage) requiresAlive() bool { return false }
+
+// RepairUpdateMessage is broadcast when how functional a site is changes.
+type RepairUpdateMessage struct {
 	Action string `json:"action"`
-	Game   string `json:"game"`
-	State  string `json:"state"`
+	Site   Site   `json:"site"`
+	Repair uint64 `json:"repair"`
+}
+
+func NewRepairUpdateMessage(site Site, repair uint64) Message {
+	return RepairUpdateMessage{
+		Action: string(RepairUpdateAction),
+		Site:   site,
+		Repair: repair,
+	}
+}
+
+func (m RepairUpdateMessage) requiresAlive() bool { return false }
+
+// UpkeepInfo is how much food a player has at the end of the day, and how
+// much more they need to eat.
+type UpkeepInfo struct {
+	Name  string `json:"name"`
+	Food  int    `json:"food"`
+	Short int    `json:"short"`
+}
+
+// UpkeepMessage is broadcast during upkeep, so players can see who is going
+// hungry and share their food.
+type UpkeepMessage struct {
+	Action     string       `json:"action"`
+	FoodPerDay int          `json:"food_per_day"`
+	Players    []UpkeepInfo `json:"players"`
+}
+
+func NewUpkeepMessage(foodPerDay int, players []UpkeepInfo) Message {
+	return UpkeepMessage{
+		Action:     string(UpkeepAction),
+		FoodPerDay: foodPerDay,
+		Players:    players,
+	}
+}
+
+func (m UpkeepMessage) requiresAlive() bool { return false }
+
+// DefenderInfo is how many bullets a player fired from the watchtower.
+type DefenderInfo struct {
+	Name    string `json:"name"`
+	Bullets int    `json:"bullets"`
 }
 
-func NewWelcomeMessage(game, state string) Message {
+// DefenseSummaryMessage is broadcast once an attack seen from the watchtower
+// has been dealt with, so everyone knows who defended the site.
+type DefenseSummaryMessage struct {
+	Action    string         `json:"action"`
+	Site      Site           `json:"site"`
+	Outcome   DefenseOutcome `json:"outcome"`
+	Defenders []DefenderInfo `json:"defenders"`
+}
+
+func NewDefenseSummaryMessage(site Site, outcome DefenseOutcome, defenders []DefenderInfo) Message {
+	return DefenseSummaryMessage{
+		Action:    string(DefenseSummaryAction),
+		Site:      site,
+		Outcome:   outcome,
+		Defenders: defenders,
+	}
+}
+
+func (m DefenseSummaryMessage) requiresAlive() bool { return false }
+
+// ErrorCode says what was wrong with a message a client sent, in a way the
+// client can act on.
+type ErrorCode string
+
+const (
+	UnknownActionError         ErrorCode = "unknown_action"
+	MalformedPayloadError      ErrorCode = "malformed_payload"
+	InvalidStateError          ErrorCode = "invalid_state"
+	NotYourEventError          ErrorCode = "not_your_event"
+	EventExpiredError          ErrorCode = "event_expired"
+	InsufficientResourcesError ErrorCode = "insufficient_resources"
+	NameTakenError             ErrorCode = "name_taken"
+	InternalError              ErrorCode = "internal_error"
+)
+
+// ErrorMessage is sent to a client, and only that client, when the server
+// couldn't act on a message it sent.
+type ErrorMessage struct {
+	Action string    `json:"action"`
+	Code   ErrorCode `json:"code"`
+	Detail string    `json:"detail"`
+}
+
+func NewErrorMessage(code ErrorCode, detail string) ErrorMessage {
+	return ErrorMessage{
+		Action: string(ErrorAction),
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+func (m ErrorMessage) requiresAlive() bool { return false }
+
+// Error lets an ErrorMessage be returned as an error, e.g. by DecodeMessage.
+func (m ErrorMessage) Error() string {
+	return fmt.Sprintf("%s: %s", m.Code, m.Detail)
+}
+
+// WelcomeMessage is sent when a player joins or resumes a game. The session
+// token can be passed to /join to resume after a dropped connection, and the
+// seed can be passed to /join to play the same game again.
+type WelcomeMessage struct {
+	Action  string `json:"action"`
+	Game    string `json:"game"`
+	State   string `json:"state"`
+	Session string `json:"session"`
+	Seed    int64  `json:"seed"`
+}
+
+func NewWelcomeMessage(game, state, session string, seed int64) Message {
 	return WelcomeMessage{
-		Action: string(WelcomeAction),
-		Game:   game,
-		State:  state,
+		Action:  string(WelcomeAction),
+		Game:    game,
+		State:   state,
+		Session: session,
+		Seed:    seed,
 	}
 }
 
 func (m WelcomeMessage) requiresAlive() bool { return false }
 
+// PlayerStatus is how a player is doing, as far as the other players can
+// tell.
+type PlayerStatus struct {
+	Name     string   `json:"name"`
+	Alive    bool     `json:"alive"`
+	Escaped  bool     `json:"escaped"`
+	Presence Presence `json:"presence"`
+}
+
+// StateSnapshotMessage is everything a client needs to draw the game from
+// scratch. It is sent when a player joins or resumes, and whenever they ask
+// for it. Time is what's left on the clock, as in SetClockMessage, and the
+// event is the one the player still has to respond to, if any.
+type StateSnapshotMessage struct {
+	Action          string                `json:"action"`
+	State           string                `json:"state"`
+	Time            int                   `json:"time"`
+	Day             int                   `json:"day"`
+	NumDays         int                   `json:"num_days"`
+	Players         []PlayerStatus        `json:"players"`
+	SiteRepairState map[Site]uint64       `json:"site_repair_state"`
+	Site            Site                  `json:"site"`
+	Inventory       map[CommodityType]int `json:"inventory"`
+	Health          int                   `json:"health"`
+	Statuses        []StatusEffect        `json:"statuses"`
+	MaxHealth       int                   `json:"max_health"`
+	Event           *EventMessage         `json:"event,omitempty"`
+}
+
+func (m StateSnapshotMessage) requiresAlive() bool { return false }
+
 // Client messages
 
 type GoBeachMessage struct {
@@ -227,14 +607,17 @@ func NewGoBeachMessage(inventory map[CommodityType]int) Message {
 func (m GoBeachMessage) requiresAlive() bool { return true }
 
 type EventResponseMessage struct {
+	Action         string `json:"action"`
 	MessageID      uint64 `json:"message_id"`
 	ClickedOK      bool   `json:"clicked_ok"`
 	ClickedAction  bool   `json:"clicked_action"`
 	ResourceAmount int    `json:"resource_amount"`
+	Target         string `json:"target,omitempty"`
 }
 
 func NewEventResponseMessage(id uint64, clicked_ok bool, clicked_action bool, amount int) EventResponseMessage {
 	return EventResponseMessage{
+		Action:         string(EventResponseAction),
 		MessageID:      id,
 		ClickedOK:      clicked_ok,
 		ClickedAction:  clicked_action,
@@ -258,6 +641,8 @@ func NewReadyMessage(ready bool) Message {
 
 func (m ReadyMessage) requiresAlive() bool { return false }
 
+// JoinMessage adds a player to the game. The client sends it whenever it
+// connects, so a player who has already joined is just caught up.
 type JoinMessage struct {
 	Action string `json:"action"`
 }
@@ -288,20 +673,83 @@ func NewDeathMessage() Message {
 
 func (m DeathMessage) requiresAlive() bool { return true }
 
+// TradeMessage is sent when a user bumps phones to trade. The token is
+// optional, and should be the same on both phones if the client can agree on
+// one.
 type TradeMessage struct {
 	Action    string `json:"action"`
 	Materials string `json:"materials"`
+	Token     string `json:"token,omitempty"`
 }
 
-func NewTradeMessage(materials string) Message {
+func NewTradeMessage(materials, token string) Message {
 	return TradeMessage{
 		Action:    string(TradeAction),
 		Materials: materials,
+		Token:     token,
 	}
 }
 
 func (m TradeMessage) requiresAlive() bool { return true }
 
+type TradeProposeMessage struct {
+	Action  string                `json:"action"`
+	To      string                `json:"to"`
+	Offer   map[CommodityType]int `json:"offer"`
+	Request map[CommodityType]int `json:"request"`
+}
+
+func NewTradeProposeMessage(to string, offer, request map[CommodityType]int) Message {
+	return TradeProposeMessage{
+		Action:  string(TradeProposeAction),
+		To:      to,
+		Offer:   offer,
+		Request: request,
+	}
+}
+
+func (m TradeProposeMessage) requiresAlive() bool { return true }
+
+type TradeCounterMessage struct {
+	Action  string                `json:"action"`
+	TradeID uint64                `json:"trade_id"`
+	Offer   map[CommodityType]int `json:"offer"`
+	Request map[CommodityType]int `json:"request"`
+}
+
+func NewTradeCounterMessage(id uint64, offer, request map[CommodityType]int) Message {
+	return TradeCounterMessage{
+		Action:  string(TradeCounterAction),
+		TradeID: id,
+		Offer:   offer,
+		Request: request,
+	}
+}
+
+func (m TradeCounterMessage) requiresAlive() bool { return true }
+
+type TradeAcceptMessage struct {
+	Action  string `json:"action"`
+	TradeID uint64 `json:"trade_id"`
+}
+
+func NewTradeAcceptMessage(id uint64) Message {
+	return TradeAcceptMessage{string(TradeAcceptAction), id}
+}
+
+func (m TradeAcceptMessage) requiresAlive() bool { return true }
+
+type TradeDeclineMessage struct {
+	Action  string `json:"action"`
+	TradeID uint64 `json:"trade_id"`
+}
+
+func NewTradeDeclineMessage(id uint64) Message {
+	return TradeDeclineMessage{string(TradeDeclineAction), id}
+}
+
+func (m TradeDeclineMessage) requiresAlive() bool { return true }
+
 type SellMessage struct {
 	Action   string `json:"action"`
 	Quantity int64  `json:"quantity"`
@@ -336,7 +784,57 @@ func NewSiteSelectionMessage(site Site) SiteSelectionMessage {
 
 func (m SiteSelectionMessage) requiresAlive() bool { return false }
 
-// Internal-only messages
+// RequestSnapshotMessage asks for a StateSnapshotMessage, e.g. when the client
+// suspects it has missed something.
+type RequestSnapshotMessage struct {
+	Action string `json:"action"`
+}
+
+func NewRequestSnapshotMessage() Message {
+	return RequestSnapshotMessage{
+		Action: string(RequestSnapshotAction),
+	}
+}
+
+func (m RequestSnapshotMessage) requiresAlive() bool { return false }
+
+// ShareFoodMessage gives some of the sender's food to another player during
+// upkeep.
+type ShareFoodMessage struct {
+	Action string `json:"action"`
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+}
+
+func NewShareFoodMessage(to string, amount int) Message {
+	return ShareFoodMessage{
+		Action: string(ShareFoodAction),
+		To:     to,
+		Amount: amount,
+	}
+}
+
+func (m ShareFoodMessage) requiresAlive() bool { return true }
+
+// UseBandageMessage spends one of the sender's bandages on themselves. It can
+// be sent at any time during a game, not just in answer to an event.
+type UseBandageMessage struct {
+	Action string `json:"action"`
+}
+
+func NewUseBandageMessage() Message {
+	return UseBandageMessage{
+		Action: string(UseBandageAction),
+	}
+}
+
+func (m UseBandageMessage) requiresAlive() bool { return true }
+
+// Debug-only messages
+
+// DefenseFailedMessage makes the attack on a site go ahead, as if nobody at
+// the watchtower defended it. In a real game, this happens once everyone at
+// the watchtower has had the chance to fire.
 type DefenseFailedMessage struct {
 	Action string `json:"action"`
 	Site   Site   `json:"site"`
@@ -351,12 +849,68 @@ func NewDefenseFailedMessage(site Site) Message {
 
 func (m DefenseFailedMessage) requiresAlive() bool { return true }
 
+// Internal-only messages
+
+// ResumeMessage is queued when a player reconnects with a session token. The
+// connection they came back on is carried along to the game thread.
+type ResumeMessage struct {
+	Action string `json:"action"`
+	conn   *websocket.Conn
+}
+
+func NewResumeMessage(conn *websocket.Conn) Message {
+	return ResumeMessage{
+		Action: string(ResumeAction),
+		conn:   conn,
+	}
+}
+
+func (m ResumeMessage) requiresAlive() bool { return false }
+
+// DisconnectMessage is queued when a player's connection drops. It carries
+// the connection which dropped, so that a stale disconnect can't affect a
+// player who has already resumed.
+type DisconnectMessage struct {
+	Action string `json:"action"`
+	conn   *websocket.Conn
+}
+
+func NewDisconnectMessage(conn *websocket.Conn) Message {
+	return DisconnectMessage{
+		Action: string(DisconnectAction),
+		conn:   conn,
+	}
+}
+
+func (m DisconnectMessage) requiresAlive() bool { return false }
+
+// PresenceMessage is queued when the server notices a player has come or
+// gone, or has gone idle.
+type PresenceMessage struct {
+	Action   string   `json:"action"`
+	Presence Presence `json:"presence"`
+}
+
+func NewPresenceMessage(presence Presence) Message {
+	return PresenceMessage{
+		Action:   string(PresenceAction),
+		Presence: presence,
+	}
+}
+
+func (m PresenceMessage) requiresAlive() bool { return false }
+
 // DecodeMessage takes data in bytes, determines which message it corresponds
-// to, and decodes it to the appropriate type.
-func DecodeMessage(data []byte) (Message, error) {
+// to, and decodes it to the appropriate type. Only actions the role allows
+// are decoded. If it can't decode the message, the error is an ErrorMessage
+// which can be sent back to the client.
+func DecodeMessage(data []byte, role Role) (Message, error) {
 	msg := BasicMessage{}
 	if err := json.Unmarshal(data, &msg); err != nil {
-		return nil, fmt.Errorf("Unable to decode message: %q", data)
+		return nil, NewErrorMessage(MalformedPayloadError, fmt.Sprintf("unable to decode message: %q", data))
+	}
+	if !role.allows(msg.Action) {
+		return nil, NewErrorMessage(UnknownActionError, fmt.Sprintf("unknown action %q", msg.Action))
 	}
 
 	// Now that we know the type of the message (based on the action) we
@@ -372,10 +926,46 @@ func DecodeMessage(data []byte) (Message, error) {
 		m := WelcomeMessage{}
 		err = json.Unmarshal(data, &m)
 		message = m
+	case string(SetClockAction):
+		m := SetClockMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(PlayerInfoUpdateAction):
+		m := PlayerInfoUpdateMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(EventAction):
+		m := EventMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
 	case string(TradeCompletedAction):
 		m := TradeCompletedMessage{}
 		err = json.Unmarshal(data, &m)
 		message = m
+	case string(InventoryUpdateAction):
+		m := InventoryUpdateMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(HealthUpdateAction):
+		m := HealthUpdateMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(RepairUpdateAction):
+		m := RepairUpdateMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(ErrorAction):
+		m := ErrorMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(PlayerDiedAction):
+		m := PlayerDiedMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(GameOverAction):
+		m := GameOverMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
 	case string(ReadyAction):
 		m := ReadyMessage{}
 		err = json.Unmarshal(data, &m)
@@ -396,6 +986,30 @@ func DecodeMessage(data []byte) (Message, error) {
 		m := TradeMessage{}
 		err = json.Unmarshal(data, &m)
 		message = m
+	case string(TradeOfferedAction):
+		m := TradeOfferedMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(TradeClosedAction):
+		m := TradeClosedMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(TradeProposeAction):
+		m := TradeProposeMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(TradeCounterAction):
+		m := TradeCounterMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(TradeAcceptAction):
+		m := TradeAcceptMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(TradeDeclineAction):
+		m := TradeDeclineMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
 	case string(TickAction):
 		m := TickMessage{}
 		err = json.Unmarshal(data, &m)
@@ -416,9 +1030,52 @@ func DecodeMessage(data []byte) (Message, error) {
 		m := SiteSelectionMessage{}
 		err = json.Unmarshal(data, &m)
 		message = m
+	case string(DefenseSummaryAction):
+		m := DefenseSummaryMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(UpkeepAction):
+		m := UpkeepMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(UseBandageAction):
+		m := UseBandageMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(ShareFoodAction):
+		m := ShareFoodMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(RequestSnapshotAction):
+		m := RequestSnapshotMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(StateSnapshotAction):
+		m := StateSnapshotMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(DefenseFailedAction):
+		m := DefenseFailedMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(ResumeAction):
+		m := ResumeMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(DisconnectAction):
+		m := DisconnectMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
+	case string(PresenceAction):
+		m := PresenceMessage{}
+		err = json.Unmarshal(data, &m)
+		message = m
 	default:
-		err = fmt.Errorf("Unknown action: %v", msg.Action)
+		return nil, NewErrorMessage(UnknownActionError, fmt.Sprintf("unknown action %q", msg.Action))
 	}
 
-	return message, err
+	if err != nil {
+		return nil, NewErrorMessage(MalformedPayloadError, fmt.Sprintf("invalid %s message: %v", msg.Action, err))
+	}
+	return message, nil
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeMessageErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		code ErrorCode
+	}{
+		{`not json`, MalformedPayloadError},
+		{`{"action": "fly"}`, UnknownActionError},
+		{`{"action": "ready", "ready": "yes"}`, MalformedPayloadError},
+	}
+	for _, test := range tests {
+		msg, err := DecodeMessage([]byte(test.data), PlayerRole)
+		if msg != nil {
+			t.Errorf("decoded %s as %v", test.data, msg)
+		}
+		if e, ok := err.(ErrorMessage); !ok || e.Code != test.code {
+			t.Errorf("decoding %s failed with %v, want %s", test.data, err, test.code)
+		}
+	}
+
+	if _, err := DecodeMessage([]byte(`{"action": "ready", "ready": true}`), PlayerRole); err != nil {
+		t.Errorf("unable to decode a ready message: %v", err)
+	}
+}
+
+func TestDecodeMessageRoles(t *testing.T) {
+	tests := []struct {
+		data    string
+		allowed []Role
+		denied  []Role
+	}{
+		{`{"action": "ready", "ready": true}`, []Role{PlayerRole, DebugPlayerRole, JournalRole}, []Role{ServerRole}},
+		{`{"action": "tick", "tick_ms": 1000}`, []Role{DebugPlayerRole, JournalRole}, []Role{PlayerRole, ServerRole}},
+		{`{"action": "defense_failed", "site": "farm"}`, []Role{DebugPlayerRole, JournalRole}, []Role{PlayerRole}},
+		{`{"action": "presence", "presence": "lost"}`, []Role{JournalRole}, []Role{PlayerRole, DebugPlayerRole}},
+		{`{"action": "welcome", "game": "test"}`, []Role{ServerRole}, []Role{PlayerRole, JournalRole}},
+	}
+	for _, test := range tests {
+		for _, role := range test.allowed {
+			if _, err := DecodeMessage([]byte(test.data), role); err != nil {
+				t.Errorf("decoding %s as %v failed: %v", test.data, role, err)
+			}
+		}
+		for _, role := range test.denied {
+			if msg, err := DecodeMessage([]byte(test.data), role); err == nil {
+				t.Errorf("decoded %s as %v into %v, want an error", test.data, role, msg)
+			}
+		}
+	}
+}
+
+// exampleMessages has a message with each action, to check they can all be
+// decoded.
+var exampleMessages = []Message{
+	NewGameStateChangedMessage(SiteVisitState),
+	NewWelcomeMessage("test", string(WaitingState), "session", 1),
+	NewSetClockMessage(SiteVisitRoundDuration),
+	NewPlayerInfoUpdateMessage([]PlayerInfo{{Name: "alice", Ready: true, Presence: PresenceConnected}}),
+	NewEventMessage("Nothing happens", "The hours pass quietly."),
+	NewPlayerDiedMessage("alice"),
+	NewGameOverMessage("players", EscapedOutcome, []string{"alice"}),
+	NewTradeCompletedMessage(`{"log":1}`),
+	TradeOfferedMessage{Action: string(TradeOfferedAction), TradeID: 1, From: "alice", To: "bob", Offer: map[CommodityType]int{Log: 1}},
+	NewTradeClosedMessage(1, TradeDeclined, ""),
+	NewInventoryUpdateMessage(map[CommodityType]int{Food: 2}),
+	NewHealthUpdateMessage(2, 3, []StatusEffect{Bleeding}),
+	NewErrorMessage(InternalError, "the game crashed, sorry"),
+	StateSnapshotMessage{Action: string(StateSnapshotAction), State: string(WaitingState)},
+	NewRepairUpdateMessage(Farm, 50),
+	NewUpkeepMessage(1, []UpkeepInfo{{Name: "alice", Food: 1}}),
+	NewDefenseSummaryMessage(Farm, AttackRepelled, []DefenderInfo{{Name: "alice", Bullets: 2}}),
+	NewReadyMessage(true),
+	NewJoinMessage(),
+	NewLeaveMessage(),
+	NewDeathMessage(),
+	NewTradeMessage(`{"log":1}`, "token"),
+	NewTradeProposeMessage("bob", map[CommodityType]int{Log: 1}, map[CommodityType]int{Food: 1}),
+	NewTradeCounterMessage(1, map[CommodityType]int{Food: 1}, nil),
+	NewTradeAcceptMessage(1),
+	NewTradeDeclineMessage(1),
+	NewSetNameMessage("alice"),
+	NewSiteSelectionMessage(Forest),
+	NewGoBeachMessage(map[CommodityType]int{Log: 1}),
+	NewEventResponseMessage(1, true, false, 0),
+	NewRequestSnapshotMessage(),
+	NewShareFoodMessage("bob", 1),
+	NewUseBandageMessage(),
+	NewTickMessage(TickInterval),
+	NewDefenseFailedMessage(Farm),
+	NewResumeMessage(nil),
+	NewDisconnectMessage(nil),
+	NewPresenceMessage(PresenceLost),
+}
+
+// originRoles is a role which can decode messages from each origin.
+var originRoles = map[MessageOrigin]Role{
+	ClientOrigin:   PlayerRole,
+	ServerOrigin:   ServerRole,
+	DebugOrigin:    DebugPlayerRole,
+	InternalOrigin: JournalRole,
+}
+
+func TestEveryActionCanBeDecoded(t *testing.T) {
+	examples := map[string]Message{}
+	for _, m := range exampleMessages {
+		data, _ := json.Marshal(m)
+		msg := BasicMessage{}
+		json.Unmarshal(data, &msg)
+		examples[msg.Action] = m
+	}
+
+	for action, origin := range actionOrigins {
+		example, ok := examples[action]
+		if !ok {
+			t.Errorf("no example %q message", action)
+			continue
+		}
+		data, _ := json.Marshal(example)
+		decoded, err := DecodeMessage(data, originRoles[origin])
+		if err != nil {
+			t.Errorf("unable to decode %s: %v", data, err)
+			continue
+		}
+		if again, _ := json.Marshal(decoded); string(again) != string(data) {
+			t.Errorf("decoded %s as %s", data, again)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// OutboxSize is how many messages can be waiting to be written to a
+	// connection before it overflows.
+	OutboxSize int = 64
+
+	// WriteTimeout is how long a single write to a connection can take
+	// before we give up on it.
+	WriteTimeout time.Duration = 10 * time.Second
+
+	// PingInterval is how often we ping the connection, so the player's
+	// read thread hears from it well within the PongTimeout.
+	PingInterval time.Duration = PongTimeout * 9 / 10
+)
+
+// OverflowPolicy decides what happens when a connection can't keep up with
+// the messages sent to it.
+type OverflowPolicy string
+
+const (
+	// DropOnOverflow drops messages which don't fit in the outbox.
+	DropOnOverflow OverflowPolicy = "drop"
+	// DisconnectOnOverflow hangs up on the player. They can resume their
+	// session, and be caught up, once they're back.
+	DisconnectOnOverflow OverflowPolicy = "disconnect"
+)
+
+// OutboxOverflow is the policy used by every new Outbox.
+var OutboxOverflow OverflowPolicy = DisconnectOnOverflow
+
+// An Outbox queues the messages for a single websocket connection, and writes
+// them from its own thread. This way a slow connection can't hold up the
+// game thread, and there is only ever one writer per connection.
+type Outbox struct {
+	conn   *websocket.Conn
+	policy OverflowPolicy
+
+	mu     sync.Mutex
+	queue  []Message
+	closed bool
+
+	// wake is signalled when there is something to write, or the outbox
+	// was closed.
+	wake chan struct{}
+}
+
+// NewOutbox constructs an outbox for a connection, and starts its writer
+// thread.
+func NewOutbox(conn *websocket.Conn) *Outbox {
+	o := &Outbox{
+		conn:   conn,
+		policy: OutboxOverflow,
+		wake:   make(chan struct{}, 1),
+	}
+	go o.run()
+	return o
+}
+
+// Send queues a message to be written. It never blocks.
+func (o *Outbox) Send(message Message) {
+	if o.enqueue(message) {
+		return
+	}
+
+	switch o.policy {
+	case DropOnOverflow:
+		log.Printf("Outbox full, dropping message: %v", message)
+	case DisconnectOnOverflow:
+		log.Printf("Outbox full, disconnecting")
+		o.conn.Close()
+		o.Close()
+	}
+}
+
+// enqueue adds a message to the queue, replacing any clock update which
+// hasn't been written yet, since only the latest one matters. It returns
+// false if the queue is full.
+func (o *Outbox) enqueue(message Message) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return true
+	}
+
+	if _, ok := message.(SetClockMessage); ok {
+		kept := o.queue[:0]
+		for _, m := range o.queue {
+			if _, ok := m.(SetClockMessage); !ok {
+				kept = append(kept, m)
+			}
+		}
+		o.queue = kept
+	}
+	if len(o.queue) >= OutboxSize {
+		return false
+	}
+	o.queue = append(o.queue, message)
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Close stops the outbox once everything already queued has been written, and
+// then closes the connection. It is safe to call more than once.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the first message off the queue. If the queue is empty, it
+// returns nil, and whether the outbox has been closed.
+func (o *Outbox) next() (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.queue) == 0 {
+		return nil, o.closed
+	}
+	m := o.queue[0]
+	o.queue[0] = nil
+	o.queue = o.queue[1:]
+	return m, false
+}
+
+// run is the writer thread.
+func (o *Outbox) run() {
+	ping := time.NewTicker(PingInterval)
+	defer ping.Stop()
+	defer o.conn.Close()
+
+	for {
+		select {
+		case <-ping.C:
+			deadline := time.Now().Add(WriteTimeout)
+			if err := o.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Printf("Ping failed: %v", err)
+				o.Close()
+				return
+			}
+			continue
+		case <-o.wake:
+		}
+
+		for {
+			message, closed := o.next()
+			if closed {
+				return
+			}
+			if message == nil {
+				break
+			}
+
+			o.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			if err := o.conn.WriteJSON(message); err != nil {
+				// The read thread will notice the connection is
+				// gone, and disconnect the player.
+				log.Printf("Write failed: %v", err)
+				o.Close()
+				return
+			}
+		}
+	}
+}
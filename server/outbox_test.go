@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestOutbox constructs an outbox without a connection or writer thread,
+// so its queue can be inspected.
+func newTestOutbox() *Outbox {
+	return &Outbox{policy: DropOnOverflow, wake: make(chan struct{}, 1)}
+}
+
+func TestOutboxCoalescesClock(t *testing.T) {
+	o := newTestOutbox()
+	o.Send(NewSetClockMessage(time.Second))
+	o.Send(NewEventMessage("title", "description"))
+	o.Send(NewSetClockMessage(2 * time.Second))
+
+	if len(o.queue) != 2 {
+		t.Fatalf("queue = %v, want an event and one clock", o.queue)
+	}
+	if clock, ok := o.queue[1].(SetClockMessage); !ok || clock.Time != 2000 {
+		t.Errorf("last message = %v, want the latest clock", o.queue[1])
+	}
+}
+
+func TestOutboxDropsOnOverflow(t *testing.T) {
+	o := newTestOutbox()
+	for i := 0; i < OutboxSize; i++ {
+		if !o.enqueue(NewEventMessage("title", "description")) {
+			t.Fatalf("outbox overflowed after %d messages", i)
+		}
+	}
+	o.Send(NewEventMessage("dropped", ""))
+	if len(o.queue) != OutboxSize {
+		t.Errorf("queue has %d messages, want %d", len(o.queue), OutboxSize)
+	}
+}
+
+func TestOutboxWritesEverythingBeforeClosing(t *testing.T) {
+	conn, client := connectTestSocket(t)
+	o := NewOutbox(conn)
+	for i := 0; i < 10; i++ {
+		o.Send(NewEventMessage("title", "description"))
+	}
+	o.Close()
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 10; i++ {
+		msg := EventMessage{}
+		if err := client.ReadJSON(&msg); err != nil {
+			t.Fatalf("read %d messages, then: %v", i, err)
+		}
+	}
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Errorf("connection is still open after the outbox was closed")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+)
+
+// IdleTimeout is how long a connected player can go without sending anything
+// before the other players are told they are idle.
+const IdleTimeout time.Duration = 30 * time.Second
+
+// Presence describes whether a player is still around.
+type Presence string
+
+const (
+	// PresenceConnected means the player is connected, and has recently
+	// done something.
+	PresenceConnected Presence = "connected"
+	// PresenceIdle means the player is connected, but hasn't done anything
+	// for a while.
+	PresenceIdle Presence = "idle"
+	// PresenceLost means the player's connection dropped, and we are
+	// waiting for them to resume their session.
+	PresenceLost Presence = "lost"
+)
+
+// PlayerInfo lists the users in join order, with their presence. If ready is
+// given, only the users in it are listed, along with whether they are ready.
+func (g *Game) PlayerInfo(ready map[User]bool) []PlayerInfo {
+	var info []PlayerInfo
+	for _, u := range g.Users() {
+		r, ok := ready[u]
+		if ready != nil && !ok {
+			continue
+		}
+		info = append(info, PlayerInfo{
+			Name:     u.Name(),
+			Ready:    r,
+			Presence: g.Presence[u],
+		})
+	}
+	return info
+}
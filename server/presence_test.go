@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPresenceIsBroadcast(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, conn, _ := newTestGame(alice, bob)
+
+	g.RecieveMessage(bob, NewPresenceMessage(PresenceIdle))
+	info := lastMessage[PlayerInfoUpdateMessage](t, conn.broadcasts, nil).Info
+	if len(info) != 2 || info[0].Presence != PresenceConnected || info[1].Presence != PresenceIdle {
+		t.Errorf("player info in the waiting room = %+v, want bob idle", info)
+	}
+
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewPresenceMessage(PresenceLost))
+	info = lastMessage[PlayerInfoUpdateMessage](t, conn.broadcasts, nil).Info
+	if len(info) != 2 || info[1].Presence != PresenceLost {
+		t.Errorf("player info during site selection = %+v, want bob lost", info)
+	}
+}
+
+func TestLostPlayerDoesNotHoldUpSiteSelection(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Forest))
+	if g.state.Name() != SiteSelectionState {
+		t.Fatalf("state = %q while bob is choosing, want %q", g.state.Name(), SiteSelectionState)
+	}
+
+	g.RecieveMessage(bob, NewPresenceMessage(PresenceLost))
+	if g.state.Name() != SiteVisitState {
+		t.Fatalf("state = %q after bob was lost, want %q", g.state.Name(), SiteVisitState)
+	}
+	if site := g.UserSites[bob]; site == NoSiteSelected {
+		t.Errorf("bob wasn't sent anywhere")
+	}
+}
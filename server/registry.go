@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// GameIdleTimeout is how long a game can go without hearing from any
+	// player before it is torn down.
+	GameIdleTimeout time.Duration = 30 * time.Minute
+
+	// ReapInterval is how often the registry looks for idle games.
+	ReapInterval time.Duration = time.Minute
+)
+
+// ErrGameNameTaken is returned when reserving a name that is already in use.
+var ErrGameNameTaken = errors.New("game name is already taken")
+
+// validGameName restricts custom game names to something that is easy to
+// share and safe to put in a URL.
+var validGameName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// checkGameName returns an error if a name given by a player can't be used
+// for a game.
+func checkGameName(name string) error {
+	if !validGameName.MatchString(name) {
+		return fmt.Errorf("invalid game name %q", name)
+	}
+	return nil
+}
+
+// GameRegistry holds all the games currently in progress, keyed by name. It
+// is safe to use from multiple goroutines.
+type GameRegistry struct {
+	mu    sync.Mutex
+	games map[string]*GameServer
+}
+
+// NewGameRegistry constructs an empty registry.
+func NewGameRegistry() *GameRegistry {
+	return &GameRegistry{
+		games: map[string]*GameServer{},
+	}
+}
+
+// Get returns the game with the given name, if it exists and is still
+// running.
+func (r *GameRegistry) Get(name string) (*GameServer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.games[name]
+	if !ok || s.Stopped() {
+		return nil, false
+	}
+	return s, true
+}
+
+// GetOrCreate returns the game with the given name, creating it with the
+// given seed and config if it doesn't exist or has already finished. It fails
+// if the name is invalid.
+func (r *GameRegistry) GetOrCreate(name string, seed int64, config GameConfig) (*GameServer, error) {
+	if err := checkGameName(name); err != nil {
+		return nil, err
+	}
+	if s, ok := r.Get(name); ok {
+		return s, nil
+	}
+	s, _ := r.add(name, r.start(name, seed, config))
+	return s, nil
+}
+
+// CreateUnique starts a new game under a freshly generated join code.
+func (r *GameRegistry) CreateUnique(seed int64, config GameConfig) *GameServer {
+	for {
+		r.mu.Lock()
+		name := GenerateGameName(func(name string) bool {
+			_, ok := r.games[name]
+			return ok
+		})
+		r.mu.Unlock()
+
+		// Someone else could take the code while the game starts, in
+		// which case we try another.
+		if s, added := r.add(name, r.start(name, seed, config)); added {
+			return s
+		}
+	}
+}
+
+// Reserve starts a new game under a custom name. It fails if the name is
+// invalid or a game with that name is already running.
+func (r *GameRegistry) Reserve(name string, seed int64, config GameConfig) (*GameServer, error) {
+	if err := checkGameName(name); err != nil {
+		return nil, err
+	}
+	if _, ok := r.Get(name); ok {
+		return nil, ErrGameNameTaken
+	}
+
+	s, added := r.add(name, r.start(name, seed, config))
+	if !added {
+		return nil, ErrGameNameTaken
+	}
+	return s, nil
+}
+
+// start constructs a new game. It opens the game's journal, so it is called
+// without holding the lock.
+func (r *GameRegistry) start(name string, seed int64, config GameConfig) *GameServer {
+	log.Printf("Creating game %q with seed %d and config %+v", name, seed, config)
+	return NewGameServer(name, seed, config)
+}
+
+// add puts a new game in the registry, unless a running game with the same
+// name got there first. In that case the new game is stopped and its journal
+// deleted, and the running one is returned instead, along with false.
+func (r *GameRegistry) add(name string, s *GameServer) (*GameServer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.games[name]; ok && !existing.Stopped() {
+		log.Printf("Game %q was created twice, keeping the first", name)
+		s.Stop()
+		s.journal.Discard()
+		return existing, false
+	}
+	s.onStop = func() { r.remove(name, s) }
+	r.games[name] = s
+	return s, true
+}
+
+// remove deletes a game from the registry, as long as the name hasn't since
+// been reused by another game.
+func (r *GameRegistry) remove(name string, s *GameServer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.games[name] == s {
+		log.Printf("Removing game %q", name)
+		delete(r.games, name)
+	}
+}
+
+// Len returns the number of games in the registry.
+func (r *GameRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.games)
+}
+
+// Reap stops every game which hasn't heard from a player in GameIdleTimeout.
+// Stopped games remove themselves from the registry.
+func (r *GameRegistry) Reap(now time.Time) {
+	r.mu.Lock()
+	var idle []*GameServer
+	for _, s := range r.games {
+		if now.Sub(s.LastActive()) > GameIdleTimeout {
+			idle = append(idle, s)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, s := range idle {
+		log.Printf("Game %q has been idle since %v", s.game.name, s.LastActive())
+		s.Stop()
+	}
+}
+
+// RunReaper is a dedicated thread which periodically reaps idle games.
+func (r *GameRegistry) RunReaper() {
+	for {
+		time.Sleep(ReapInterval)
+		r.Reap(time.Now())
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetOrCreateReusesRunningGame(t *testing.T) {
+	r := NewGameRegistry()
+	s, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	defer s.Stop()
+
+	if got, _ := r.GetOrCreate("test", 2, DefaultGameConfig()); got != s {
+		t.Errorf("got a new game, want the running one")
+	}
+	if got := r.Len(); got != 1 {
+		t.Errorf("registry has %d games, want 1", got)
+	}
+}
+
+func TestGetOrCreateReplacesStoppedGame(t *testing.T) {
+	r := NewGameRegistry()
+	s, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	s.Stop()
+
+	next, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	defer next.Stop()
+	if next == s {
+		t.Errorf("got the stopped game, want a new one")
+	}
+}
+
+func TestGetOrCreateFromManyThreads(t *testing.T) {
+	r := NewGameRegistry()
+	games := make([]*GameServer, 10)
+	var wg sync.WaitGroup
+	for i := range games {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			games[i], _ = r.GetOrCreate("test", 1, DefaultGameConfig())
+		}(i)
+	}
+	wg.Wait()
+	defer games[0].Stop()
+
+	for _, s := range games {
+		if s != games[0] {
+			t.Fatalf("threads got different games for the same name")
+		}
+	}
+	if got := r.Len(); got != 1 {
+		t.Errorf("registry has %d games, want 1", got)
+	}
+}
+
+func TestLosingGameLeavesNoJournal(t *testing.T) {
+	defer func(dir string) { JournalDir = dir }(JournalDir)
+	JournalDir = t.TempDir()
+	r := NewGameRegistry()
+
+	first, _ := r.add("test", r.start("test", 1, DefaultGameConfig()))
+	defer first.Stop()
+	if _, added := r.add("test", r.start("test", 1, DefaultGameConfig())); added {
+		t.Fatalf("added a second game called test")
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(JournalDir, "*.jsonl"))
+	if len(paths) != 1 {
+		t.Errorf("journals = %v, want just the running game's", paths)
+	}
+}
+
+func TestPanickingGameDoesntStopOthers(t *testing.T) {
+	r := NewGameRegistry()
+	broken, _ := r.GetOrCreate("broken", 1, DefaultGameConfig())
+	other, _ := r.GetOrCreate("other", 1, DefaultGameConfig())
+	defer other.Stop()
+
+	// A message without a player panics on the game thread.
+	broken.send(NewEvent(nil, NewReadyMessage(true)))
+	select {
+	case <-broken.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("game didn't stop after panicking")
+	}
+
+	if _, ok := r.Get("broken"); ok {
+		t.Errorf("panicked game is still in the registry")
+	}
+	if s, ok := r.Get("other"); !ok || s != other || other.Stopped() {
+		t.Errorf("other game stopped when one panicked")
+	}
+	if !other.send(NewEvent(nil, NewTickMessage(TickInterval))) {
+		t.Errorf("other game isn't handling events")
+	}
+}
+
+func TestStoppedGameIsRemoved(t *testing.T) {
+	r := NewGameRegistry()
+	s, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	s.Stop()
+
+	if got := r.Len(); got != 0 {
+		t.Errorf("registry has %d games after stopping the only one, want 0", got)
+	}
+	if _, ok := r.Get("test"); ok {
+		t.Errorf("found a stopped game")
+	}
+}
+
+func TestRemoveKeepsReplacement(t *testing.T) {
+	r := NewGameRegistry()
+	old, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	old.Stop()
+	next, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	defer next.Stop()
+
+	r.remove("test", old)
+	if s, ok := r.Get("test"); !ok || s != next {
+		t.Errorf("removing the old game removed its replacement")
+	}
+}
+
+func TestReserveRejectsRunningName(t *testing.T) {
+	r := NewGameRegistry()
+	s, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	defer s.Stop()
+
+	if _, err := r.Reserve("test", 1, DefaultGameConfig()); err != ErrGameNameTaken {
+		t.Errorf("error = %v, want %v", err, ErrGameNameTaken)
+	}
+}
+
+func TestReapStopsIdleGames(t *testing.T) {
+	r := NewGameRegistry()
+	s, _ := r.GetOrCreate("test", 1, DefaultGameConfig())
+	defer s.Stop()
+
+	r.Reap(s.LastActive().Add(GameIdleTimeout))
+	if s.Stopped() {
+		t.Fatalf("game was reaped before it was idle for %v", GameIdleTimeout)
+	}
+
+	r.Reap(s.LastActive().Add(GameIdleTimeout + time.Second))
+	if !s.Stopped() {
+		t.Errorf("idle game wasn't reaped")
+	}
+	if got := r.Len(); got != 0 {
+		t.Errorf("registry has %d games after reaping, want 0", got)
+	}
+}
+
+func TestGetOrCreateRejectsInvalidName(t *testing.T) {
+	r := NewGameRegistry()
+	if _, err := r.GetOrCreate("../escape", 1, DefaultGameConfig()); err == nil {
+		t.Errorf("created a game called ../escape")
+	}
+	if got := r.Len(); got != 0 {
+		t.Errorf("registry has %d games, want 0", got)
+	}
+}
+
+func TestValidGameNames(t *testing.T) {
+	for _, name := range []string{"ABCD", "my-game_2", strings.Repeat("a", 32)} {
+		if err := checkGameName(name); err != nil {
+			t.Errorf("%q is invalid: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../x", "a/b", "with space", "caf\u00e9", strings.Repeat("a", 33)} {
+		if err := checkGameName(name); err == nil {
+			t.Errorf("%q is valid, want it rejected", name)
+		}
+	}
+}
+
+func TestGenerateGameNameUsesCodeAlphabet(t *testing.T) {
+	name := GenerateGameName(func(string) bool { return false })
+	if len(name) != GameCodeLength {
+		t.Errorf("name %q has length %d, want %d", name, len(name), GameCodeLength)
+	}
+	for _, c := range name {
+		if !strings.ContainsRune(gameCodeAlphabet, c) {
+			t.Errorf("name %q contains %q, which isn't in the code alphabet", name, c)
+		}
+	}
+	if err := checkGameName(name); err != nil {
+		t.Errorf("generated name is invalid: %v", err)
+	}
+}
+
+func TestGenerateGameNameRetriesTakenNames(t *testing.T) {
+	tries := 0
+	name := GenerateGameName(func(name string) bool {
+		tries++
+		return tries <= 3
+	})
+	if tries != 4 {
+		t.Errorf("tried %d names, want 4", tries)
+	}
+	if len(name) != GameCodeLength {
+		t.Errorf("name %q has length %d, want %d", name, len(name), GameCodeLength)
+	}
+}
+
+func TestGenerateGameNameGetsLongerWhenCodesRunOut(t *testing.T) {
+	name := GenerateGameName(func(name string) bool {
+		return len(name) == GameCodeLength
+	})
+	if len(name) != GameCodeLength+1 {
+		t.Errorf("name %q has length %d, want %d", name, len(name), GameCodeLength+1)
+	}
+}
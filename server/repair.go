@@ -0,0 +1,75 @@
+package main
+
+const (
+	// MaxRepairState is how functional a fully repaired site is, as a
+	// percentage.
+	MaxRepairState uint64 = 100
+
+	// RepairPerLog is how much each log spent repairing a site helps.
+	RepairPerLog int = 10
+
+	// RepairDecay is how much every site falls apart at the end of each
+	// day.
+	RepairDecay int = 5
+
+	// AttackRepairDamage is how much an attack which got past the
+	// watchtower damages the site it reaches.
+	AttackRepairDamage int = 10
+)
+
+// ModifyRepair changes how functional a site is by the given amount, keeping
+// it between 0 and MaxRepairState. If it changed, everyone is told.
+func (g *Game) ModifyRepair(site Site, amount int) {
+	repair := int(g.SiteRepairState[site]) + amount
+	if repair > int(MaxRepairState) {
+		repair = int(MaxRepairState)
+	}
+	if repair < 0 {
+		repair = 0
+	}
+	if uint64(repair) == g.SiteRepairState[site] {
+		return
+	}
+
+	g.SiteRepairState[site] = uint64(repair)
+	g.connection.Broadcast(NewRepairUpdateMessage(site, uint64(repair)))
+}
+
+// DecayRepair wears down every site at the end of a day. There is nothing to
+// repair at the beach.
+func (g *Game) DecayRepair() {
+	for _, site := range AllSites() {
+		if site != Beach {
+			g.ModifyRepair(site, -g.Config.RepairDecay)
+		}
+	}
+}
+
+// RepairBonus returns the share of a bonus a site provides, in proportion to
+// how functional it is. Any fraction is rounded up or down at random, so e.g.
+// a half repaired site gives a bonus of 1 half of the time.
+func (g *Game) RepairBonus(site Site, bonus int) int {
+	scaled := bonus * int(g.SiteRepairState[site])
+	n := scaled / int(MaxRepairState)
+	if fraction := scaled % int(MaxRepairState); fraction > 0 && g.rng.Intn(int(MaxRepairState)) < fraction {
+		n++
+	}
+	return n
+}
+
+// RepairWorks returns true as often as the site is functional, e.g. a
+// watchtower at 70% spots 70% of attacks.
+func (g *Game) RepairWorks(site Site) bool {
+	return g.rng.Intn(int(MaxRepairState)) < int(g.SiteRepairState[site])
+}
+
+// repairWeight scales an event's weight at a site between half and all of
+// it, depending on how functional the site is. If broken is true, broken
+// sites get the full weight instead.
+func repairWeight(g *Game, site Site, weight int, broken bool) int {
+	repair := int(g.SiteRepairState[site])
+	if broken {
+		repair = int(MaxRepairState) - repair
+	}
+	return weight/2 + weight/2*repair/int(MaxRepairState)
+}
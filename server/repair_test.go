@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+// repairOf matches the repair updates for a site.
+func repairOf(site Site) func(RepairUpdateMessage) bool {
+	return func(m RepairUpdateMessage) bool { return m.Site == site }
+}
+
+func TestRepairIsCapped(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, conn, _ := newTestGame(alice)
+	g.Inventory(alice)[Log] = 10
+	startVisit(t, g, alice, Forest)
+
+	event := alice.lastEvent(t)
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, true, false, 10))
+
+	if got := g.SiteRepairState[Forest]; got != MaxRepairState {
+		t.Errorf("forest repair = %d, want %d", got, MaxRepairState)
+	}
+	if got := lastMessage(t, conn.broadcasts, repairOf(Forest)).Repair; got != MaxRepairState {
+		t.Errorf("broadcast forest repair = %d, want %d", got, MaxRepairState)
+	}
+}
+
+func TestRepairDecaysEachDay(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, conn, c := newTestGame(alice)
+	startVisit(t, g, alice, Beach)
+	g.SiteRepairState[Forest] = InitialRepairState
+	g.SiteRepairState[Farm] = 2
+
+	for i := 0; i < NumSiteVisitRounds; i++ {
+		c.Advance(SiteVisitRoundDuration)
+		c.Advance(SiteVisitStatusDuration)
+	}
+	if g.state.Name() != UpkeepState {
+		t.Fatalf("state = %q after the beach visit, want %q", g.state.Name(), UpkeepState)
+	}
+
+	if got, want := g.SiteRepairState[Forest], InitialRepairState-uint64(RepairDecay); got != want {
+		t.Errorf("forest repair = %d, want %d", got, want)
+	}
+	if got := g.SiteRepairState[Farm]; got != 0 {
+		t.Errorf("farm repair = %d, want 0", got)
+	}
+	if got := lastMessage(t, conn.broadcasts, repairOf(Farm)).Repair; got != 0 {
+		t.Errorf("broadcast farm repair = %d, want 0", got)
+	}
+}
+
+func TestFailedDefenseDamagesSite(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	s := startVisit(t, g, alice, Forest)
+	g.SiteRepairState[Farm] = InitialRepairState
+
+	s.DefenseFailed(Farm)
+	if got, want := g.SiteRepairState[Farm], InitialRepairState-uint64(AttackRepairDamage); got != want {
+		t.Errorf("farm repair = %d, want %d", got, want)
+	}
+}
+
+func TestBrokenWatchtowerMissesAttacks(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	g.SiteRepairState[Watchtower] = 0
+	if g.RepairWorks(Watchtower) {
+		t.Errorf("a broken watchtower spotted an attack")
+	}
+
+	g.SiteRepairState[Watchtower] = MaxRepairState
+	if !g.RepairWorks(Watchtower) {
+		t.Errorf("a fully repaired watchtower missed an attack")
+	}
+}
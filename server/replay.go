@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// replayUser stands in for a Player when replaying a journal. Everything the
+// game sends it is recorded by the connection.
+type replayUser struct {
+	name       string
+	player     int
+	connection *replayConnection
+}
+
+func (u *replayUser) Message(message Message) error {
+	u.connection.record(OutboundRecord, u.player, message)
+	return nil
+}
+
+func (u *replayUser) Name() string        { return u.name }
+func (u *replayUser) SetName(name string) { u.name = name }
+func (u *replayUser) SetAlive(alive bool) {}
+func (u *replayUser) Session() string     { return "" }
+
+// replayConnection is the GameConnection used when replaying a journal. It
+// records the messages the game produces, so they can be compared with the
+// journal.
+type replayConnection struct {
+	users    map[int]*replayUser
+	produced []JournalRecord
+}
+
+func (c *replayConnection) record(kind string, player int, message Message) {
+	data, err := json.Marshal(journaledMessage(message))
+	if err != nil {
+		data = []byte(fmt.Sprintf("%q", err.Error()))
+	}
+	c.produced = append(c.produced, JournalRecord{
+		Kind:    kind,
+		Player:  player,
+		Message: data,
+	})
+}
+
+func (c *replayConnection) Broadcast(message Message) error {
+	c.record(BroadcastRecord, 0, message)
+	return nil
+}
+
+func (c *replayConnection) Stop() {}
+
+func (c *replayConnection) user(player int, name string) *replayUser {
+	u, ok := c.users[player]
+	if !ok {
+		u = &replayUser{name: name, player: player, connection: c}
+		c.users[player] = u
+	}
+	return u
+}
+
+// Replay feeds a journal back into a fresh game with the same seed, and checks
+// that the game sends exactly the same messages as it did the first time.
+func Replay(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	var conn *replayConnection
+	var game *Game
+	var expected []JournalRecord
+	for scanner.Scan() {
+		record := JournalRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("invalid journal record %q: %v", scanner.Text(), err)
+		}
+
+		if record.Kind == GameRecord {
+			if game != nil {
+				return fmt.Errorf("record %d: journal holds more than one game", record.Seq)
+			}
+			conn = &replayConnection{users: map[int]*replayUser{}}
+			config := DefaultGameConfig()
+			if record.Config != nil {
+				config = *record.Config
+			}
+			game = NewGameWithConfig(record.Game, conn, record.Seed, config)
+			continue
+		}
+		if game == nil {
+			return fmt.Errorf("journal doesn't start with a %q record", GameRecord)
+		}
+
+		switch record.Kind {
+		case InboundRecord:
+			msg, err := DecodeMessage(record.Message, JournalRole)
+			if err != nil {
+				return fmt.Errorf("record %d: %v", record.Seq, err)
+			}
+			game.RecieveMessage(conn.user(record.Player, record.Name), msg)
+		case TickRecord:
+			game.Tick(time.Duration(record.Tick) * time.Millisecond)
+		case OutboundRecord, BroadcastRecord:
+			expected = append(expected, record)
+		default:
+			return fmt.Errorf("record %d: unknown kind %q", record.Seq, record.Kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if game == nil {
+		return fmt.Errorf("empty journal")
+	}
+
+	return compareRecords(expected, conn.produced)
+}
+
+// compareRecords checks that the replayed game produced the same messages,
+// in the same order, as the journal.
+func compareRecords(expected, produced []JournalRecord) error {
+	for i, e := range expected {
+		if i >= len(produced) {
+			return fmt.Errorf("replay stopped after %d messages, expected %s", i, e.Message)
+		}
+		p := produced[i]
+
+		var a, b bytes.Buffer
+		json.Compact(&a, e.Message)
+		json.Compact(&b, p.Message)
+		if e.Kind != p.Kind || e.Player != p.Player || a.String() != b.String() {
+			return fmt.Errorf("message %d differs (record %d):\n  journal: %s %d %s\n  replay:  %s %d %s",
+				i, e.Seq, e.Kind, e.Player, a.String(), p.Kind, p.Player, b.String())
+		}
+	}
+	if len(produced) > len(expected) {
+		return fmt.Errorf("replay produced %d extra messages, starting with %s",
+			len(produced)-len(expected), produced[len(expected)].Message)
+	}
+	return nil
+}
+
+// ReplayFile replays the journal at path.
+func ReplayFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Replay(f)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journalUser is a User which journals everything sent to it, like a Player.
+type journalUser struct {
+	name    string
+	journal *Journal
+}
+
+func (u *journalUser) Message(message Message) error {
+	u.journal.Outbound(u, message)
+	return nil
+}
+
+func (u *journalUser) Name() string        { return u.name }
+func (u *journalUser) SetName(name string) { u.name = name }
+func (u *journalUser) SetAlive(alive bool) {}
+func (u *journalUser) Session() string     { return "session-" + u.name }
+
+// journalConnection is a GameConnection which journals broadcasts, like a
+// GameServer.
+type journalConnection struct {
+	journal *Journal
+}
+
+func (c *journalConnection) Broadcast(message Message) error {
+	c.journal.Broadcast(message)
+	return nil
+}
+
+func (c *journalConnection) Stop() {}
+
+// playJournaledGame plays a short two player game, and returns its journal.
+func playJournaledGame(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	j := NewJournal(&buf)
+	j.write(JournalRecord{Kind: GameRecord, Game: "test", Seed: 42})
+
+	g := NewGame("test", &journalConnection{j}, 42)
+	send := func(u User, m Message) {
+		j.Inbound(u, m)
+		g.RecieveMessage(u, m)
+	}
+
+	alice := &journalUser{"alice", j}
+	bob := &journalUser{"bob", j}
+	send(alice, NewJoinMessage())
+	send(bob, NewJoinMessage())
+	// The client joins again whenever it connects.
+	send(alice, NewJoinMessage())
+	send(alice, NewReadyMessage(true))
+	send(bob, NewReadyMessage(true))
+	send(alice, NewSiteSelectionMessage(Forest))
+	send(bob, NewSiteSelectionMessage(Watchtower))
+	send(bob, NewPresenceMessage(PresenceIdle))
+
+	for tick := TickInterval; tick < time.Minute; tick += TickInterval {
+		j.Tick(tick)
+		g.Tick(tick)
+	}
+	return &buf
+}
+
+func TestReplayMatchesJournal(t *testing.T) {
+	buf := playJournaledGame(t)
+	if err := Replay(buf); err != nil {
+		t.Errorf("replay failed: %v", err)
+	}
+}
+
+func TestJournalDoesntRecordSessions(t *testing.T) {
+	buf := playJournaledGame(t)
+	if strings.Contains(buf.String(), "session-") {
+		t.Errorf("journal contains a session token")
+	}
+}
+
+func TestReplayDetectsDifferences(t *testing.T) {
+	buf := playJournaledGame(t)
+	tampered := strings.Replace(buf.String(), `"name":"alice"`, `"name":"carol"`, 1)
+	if err := Replay(strings.NewReader(tampered)); err == nil {
+		t.Errorf("replay of a tampered journal succeeded")
+	}
+}
+
+func TestReplayRejectsSeveralGames(t *testing.T) {
+	first := playJournaledGame(t)
+	second := playJournaledGame(t)
+	if err := Replay(strings.NewReader(first.String() + second.String())); err == nil {
+		t.Errorf("replay of two games in one journal succeeded")
+	}
+}
+
+func TestOpenJournalCreatesFreshFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		j, err := OpenJournal(dir, "test", 42, DefaultGameConfig())
+		if err != nil {
+			t.Fatalf("unable to open journal: %v", err)
+		}
+		j.Close()
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(paths) != 2 {
+		t.Fatalf("journals = %v, want one for each game", paths)
+	}
+	for _, path := range paths {
+		data, _ := os.ReadFile(path)
+		if n := strings.Count(string(data), "\n"); n != 1 {
+			t.Errorf("%s has %d records, want just the game", path, n)
+		}
+	}
+}
+
+func TestOpenJournalRejectsInvalidNames(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenJournal(filepath.Join(dir, "journals"), "../escape", 42, DefaultGameConfig()); err == nil {
+		t.Errorf("opened a journal for a game called ../escape")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape-42.jsonl")); err == nil {
+		t.Errorf("journal was written outside the journal directory")
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"log"
+	"math/rand"
+	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,19 +16,82 @@ const (
 	// TickInterval is the nominal time between ticks. All timing is done in
 	// increments of the TickInterval. It's kind of like the frame rate.
 	TickInterval time.Duration = 300 * time.Millisecond
+
+	// GameCodeLength is the length of generated game names. Codes only get
+	// longer if we can't find a free one of this length.
+	GameCodeLength int = 4
+	// GameCodeAttempts is how many codes of each length we try before
+	// making them longer.
+	GameCodeAttempts int = 20
+
+	// ReconnectGracePeriod is how long a player who lost their connection
+	// keeps their place in the game, waiting for them to come back.
+	ReconnectGracePeriod time.Duration = 60 * time.Second
+
+	// PongTimeout is how long we wait to hear anything from a connection,
+	// including a reply to our pings, before deciding it is dead.
+	PongTimeout time.Duration = 60 * time.Second
+
+	// MaxMessageSize is the largest message a player can send us.
+	MaxMessageSize int64 = 8192
 )
 
+// JournalDir is the directory game journals are written to. If it is empty,
+// games aren't journaled.
+var JournalDir string
+
+// DebugActions lets players send debug actions, e.g. to advance the game
+// clock. It must only be enabled while developing.
+var DebugActions bool
+
+// gameCodeAlphabet leaves out letters which are easily confused when read
+// out loud or typed on a phone.
+const gameCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// Clock is the source of real time for a GameServer. It can be replaced to
+// control time, e.g. in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is a Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
 // Player is an implementation of User with websockets.
 type Player struct {
 	name       string
+	session    string
 	Connection *websocket.Conn
 	alive      bool
+
+	// outbox writes messages to the Connection. It is replaced along with
+	// the Connection when the player resumes their session.
+	outbox *Outbox
+
+	// connected is false while we wait for the player to resume their
+	// session after their connection dropped.
+	connected bool
+
+	// presence is what the game was last told about the player, and
+	// lastHeard is the game time they last sent us a message.
+	presence  Presence
+	lastHeard time.Duration
+
+	journal *Journal
 }
 
 func (p *Player) Name() string {
 	return p.name
 }
 
+func (p *Player) Session() string {
+	return p.session
+}
+
 func (p *Player) SetName(name string) {
 	p.name = name
 }
@@ -39,18 +107,55 @@ func NewPlayer() User {
 
 // Message sends a player a message.
 func (p *Player) Message(message Message) error {
+	p.journal.Outbound(p, message)
+	return p.write(message)
+}
+
+// write queues a message for a player, without journaling it.
+func (p *Player) write(message Message) error {
+	if !p.connected {
+		log.Printf("Not sending message to disconnected Player[name=%v]: %v", p.Name(), message)
+		return nil
+	}
 	if !p.alive && message.requiresAlive() {
 		log.Printf("Not sending message to unalive Player[name=%v]: %v", p.Name(), message)
 		return nil
 	}
 	log.Printf("Sending message to Player[name=%v]: %v", p.Name(), message)
-	return p.Connection.WriteJSON(message)
+	p.outbox.Send(message)
+	return nil
+}
+
+// GenerateGameName generates a short random join code for the game, in case
+// the user didn't specify one when they connected. The taken function reports
+// whether a name is already in use, and the returned name never is.
+func GenerateGameName(taken func(string) bool) string {
+	for length := GameCodeLength; ; length++ {
+		for i := 0; i < GameCodeAttempts; i++ {
+			code := make([]byte, length)
+			for j := range code {
+				code[j] = gameCodeAlphabet[rand.Intn(len(gameCodeAlphabet))]
+			}
+			if !taken(string(code)) {
+				return string(code)
+			}
+		}
+	}
+}
+
+// NewSeed picks a seed for a game, in case the user didn't specify one.
+func NewSeed() int64 {
+	return time.Now().UnixNano()
 }
 
-// GenerateGameName generates a random name for the game, in case
-// the user didn't specify one when they connected.
-func GenerateGameName() string {
-	return "test"
+// NewSessionToken generates a random token which a player can use to resume
+// their place in a game after their connection drops.
+func NewSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		log.Printf("Unable to generate session token: %v", err)
+	}
+	return hex.EncodeToString(b)
 }
 
 // An Event is a combination of a Message and the Player who originated the
@@ -72,14 +177,32 @@ func NewEvent(player *Player, message Message) Event {
 type GameServer struct {
 	players          []*Player
 	game             *Game
+	clock            Clock
+	journal          *Journal
 	incomingMessages chan Event
+
+	// The game time at which each disconnected player's grace period
+	// runs out.
+	disconnected map[*Player]time.Duration
+
+	// done is closed once the game is over, which shuts down all the
+	// game's threads.
+	done     chan struct{}
+	stopOnce sync.Once
+	onStop   func()
+
+	// lastActive is the last time a player sent us anything. It is read
+	// from other threads, so it is guarded by mu.
+	mu         sync.Mutex
+	lastActive time.Time
 }
 
 // Broadcast sends a message to every Player.
 func (s *GameServer) Broadcast(message Message) error {
 	log.Printf("Broadcast: %v", message)
+	s.journal.Broadcast(message)
 	for _, p := range s.players {
-		err := p.Message(message)
+		err := p.write(message)
 		if err != nil {
 			log.Printf("Write failed during broadcast: %v\n", err)
 		}
@@ -87,72 +210,318 @@ func (s *GameServer) Broadcast(message Message) error {
 	return nil
 }
 
+// Stop shuts down the game clock, the game thread and all the player
+// connections. It is safe to call more than once, from any thread.
+func (s *GameServer) Stop() {
+	s.stopOnce.Do(func() {
+		log.Printf("Stopping game %q", s.game.name)
+		close(s.done)
+		if s.onStop != nil {
+			s.onStop()
+		}
+	})
+}
+
+// Stopped returns true once the game has been stopped.
+func (s *GameServer) Stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// LastActive returns the last time any player sent a message to the game.
+func (s *GameServer) LastActive() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastActive
+}
+
+func (s *GameServer) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastActive = s.clock.Now()
+}
+
+// send queues an event for the game thread. It returns false if the game has
+// already stopped.
+func (s *GameServer) send(event Event) bool {
+	select {
+	case <-s.done:
+		return false
+	case s.incomingMessages <- event:
+		return true
+	}
+}
+
 // AddPlayer is called by the main thread to add a player to our game. In fact, it
 // queues a JoinMessage from this new player, which our game thread picks up.
-func (s *GameServer) AddPlayer(player Player) {
+// It returns false if the game has already stopped.
+func (s *GameServer) AddPlayer(player Player) bool {
 	log.Printf("Adding new player %q to game %q", player.Name(), s.game.name)
 
-	s.incomingMessages <- NewEvent(&player, NewJoinMessage())
+	return s.send(NewEvent(&player, NewJoinMessage()))
+}
+
+// ResumePlayer is called by the main thread when a player reconnects with a
+// session token. The new connection is handed to the game thread, which binds
+// it to the existing player. It returns false if the game has already
+// stopped.
+func (s *GameServer) ResumePlayer(player Player) bool {
+	log.Printf("Resuming session for player %q in game %q", player.Name(), s.game.name)
+
+	return s.send(NewEvent(&player, NewResumeMessage(player.Connection)))
+}
+
+// resumePlayer rebinds a new connection to the player with the same session,
+// if there is one. Otherwise the player is added as if they had just joined,
+// with a session of their own, so clients can't choose their tokens.
+func (s *GameServer) resumePlayer(player *Player, conn *websocket.Conn) {
+	var existing *Player
+	for _, p := range s.players {
+		if p.session == player.session {
+			existing = p
+			break
+		}
+	}
+
+	if existing == nil {
+		log.Printf("No session to resume for player %q, joining instead", player.Name())
+		player.session = NewSessionToken()
+		s.addPlayer(player, NewJoinMessage())
+		return
+	}
+
+	if existing.Connection != conn {
+		existing.outbox.Close()
+	}
+	existing.Connection = conn
+	existing.outbox = NewOutbox(conn)
+	existing.connected = true
+	existing.lastHeard = s.game.GetTime()
+	delete(s.disconnected, existing)
+
+	go s.HandleCommunication(existing, conn, nil)
+	s.deliver(existing, NewResumeMessage(conn))
+	s.updatePresence(existing)
+}
+
+// disconnectPlayer starts the grace period for a player whose connection
+// dropped. If they haven't resumed their session when it runs out, they
+// leave the game.
+func (s *GameServer) disconnectPlayer(player *Player, conn *websocket.Conn) {
+	// The player might have already resumed on a new connection.
+	if player.Connection != conn {
+		return
+	}
+	log.Printf("Player %q disconnected, waiting for them to resume", player.Name())
+	player.connected = false
+	s.disconnected[player] = s.game.GetTime() + ReconnectGracePeriod
+	s.updatePresence(player)
+}
+
+// updatePresence tells the game if a player has come or gone, or gone idle.
+func (s *GameServer) updatePresence(player *Player) {
+	presence := PresenceConnected
+	if !player.connected {
+		presence = PresenceLost
+	} else if s.game.GetTime()-player.lastHeard > IdleTimeout {
+		presence = PresenceIdle
+	}
+
+	if presence != player.presence {
+		log.Printf("Player %q is now %s", player.Name(), presence)
+		player.presence = presence
+		s.deliver(player, NewPresenceMessage(presence))
+	}
+}
+
+// expireDisconnected removes the players whose grace period has run out.
+func (s *GameServer) expireDisconnected() {
+	for p, deadline := range s.disconnected {
+		if s.game.GetTime() >= deadline {
+			log.Printf("Player %q never came back", p.Name())
+			delete(s.disconnected, p)
+			s.deliver(p, NewLeaveMessage())
+			s.removePlayer(p)
+		}
+	}
+}
+
+// addPlayer starts handling messages from a new player.
+func (s *GameServer) addPlayer(player *Player, greeting Message) {
+	player.journal = s.journal
+	player.outbox = NewOutbox(player.Connection)
+	player.presence = PresenceConnected
+	player.lastHeard = s.game.GetTime()
+	s.players = append(s.players, player)
+	go s.HandleCommunication(player, player.Connection, greeting)
+}
+
+// deliver passes a message from a player to the game, journaling it on the
+// way.
+func (s *GameServer) deliver(player *Player, message Message) {
+	s.journal.Inbound(player, message)
+	s.game.RecieveMessage(player, message)
+}
+
+// tick advances the game clock, journaling the tick on the way.
+func (s *GameServer) tick(t time.Duration) {
+	s.journal.Tick(t)
+	s.game.Tick(t)
+}
+
+// removePlayer forgets about a player whose connection has gone away. Once
+// the last player leaves, the game is stopped.
+func (s *GameServer) removePlayer(player *Player) {
+	player.outbox.Close()
+	for i, p := range s.players {
+		if p == player {
+			s.players = append(s.players[:i], s.players[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.players) == 0 {
+		log.Printf("Game %q is empty", s.game.name)
+		s.Stop()
+	}
 }
 
 // HandleCommunication is the main game loop which reads messages from players.
 // This thread is where all of the game state logic is called from, including
-// timer callbacks, etc.
-func (s *GameServer) HandleCommunication(player *Player) {
-	// Send a join message as we arrive.
-	s.incomingMessages <- NewEvent(player, NewJoinMessage())
+// timer callbacks, etc. The greeting, if any, is sent on the player's behalf
+// as we arrive.
+func (s *GameServer) HandleCommunication(player *Player, conn *websocket.Conn, greeting Message) {
+	if greeting != nil && !s.send(NewEvent(player, greeting)) {
+		return
+	}
+
+	// Every message, and every reply to the pings sent by the outbox,
+	// keeps the connection alive. If the phone vanishes, the read times
+	// out and the player is disconnected.
+	conn.SetReadLimit(MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(PongTimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(PongTimeout))
+		return nil
+	})
 
 	for {
-		t, data, err := player.Connection.ReadMessage()
+		t, data, err := conn.ReadMessage()
 		if err != nil {
 			log.Printf("Websocket[name=%v] read error: %v", player.Name(), err)
-			s.incomingMessages <- NewEvent(player, NewLeaveMessage())
+			s.send(NewEvent(player, NewDisconnectMessage(conn)))
 			return
 		}
+		conn.SetReadDeadline(time.Now().Add(PongTimeout))
 
 		if t != websocket.TextMessage {
 			log.Printf("Websocket[name=%v] sent binary message", player.Name())
 		}
 
-		msg, err := DecodeMessage(data)
-		log.Printf("Player[name=%v] sent message: %v", player.Name(), msg)
+		role := PlayerRole
+		if DebugActions {
+			role = DebugPlayerRole
+		}
+		msg, err := DecodeMessage(data, role)
 		if err != nil {
 			log.Printf("Websocket[name=%v] sent invalid message: %v", player.Name(), err)
+			reply, ok := err.(ErrorMessage)
+			if !ok {
+				reply = NewErrorMessage(MalformedPayloadError, err.Error())
+			}
+			// The reply is sent from the game thread, which owns the
+			// player's connection.
+			msg = reply
+		} else {
+			log.Printf("Player[name=%v] sent message: %v", player.Name(), msg)
+		}
+		if !s.send(NewEvent(player, msg)) {
+			return
 		}
-		s.incomingMessages <- NewEvent(player, msg)
 	}
 }
 
 // HandleMessages is called on a new thread, once for each Player. It simply gets
 // messages from the Player and sends them over to the game thread to be handled.
 func (s *GameServer) HandleMessages() {
+	defer func() {
+		// The game is over, so hang up on everyone once they have
+		// been sent everything. Their read threads will notice and
+		// exit.
+		for _, p := range s.players {
+			p.outbox.Close()
+		}
+		s.journal.Close()
+	}()
+
 	for {
-		event := <-s.incomingMessages
-		switch msg := event.Message.(type) {
-		case TickMessage:
-			s.game.Tick(time.Duration(msg.Tick) * time.Millisecond)
-		case JoinMessage:
-			new := true
-			for _, x := range s.players {
-				if *event.Player == *x {
-					new = false
-					break
-				}
-			}
+		var event Event
+		select {
+		case <-s.done:
+			return
+		case event = <-s.incomingMessages:
+		}
+
+		if event.Player != nil {
+			s.touch()
+		}
+		s.handle(event)
+	}
+}
+
+// handle acts on a single event on the game thread. If the game panics, it is
+// stopped, without affecting any other game.
+func (s *GameServer) handle(event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Game %q panicked handling %v: %v\n%s", s.game.name, event.Message, r, debug.Stack())
+			s.Broadcast(NewErrorMessage(InternalError, "the game crashed, sorry"))
+			s.Stop()
+		}
+	}()
 
-			if new {
-				// On the first pass, set up the player and begin handling
-				// their messages for them.
-				s.players = append(s.players, event.Player)
-				go s.HandleCommunication(event.Player)
-			} else {
-				// On subsequent passes, we just want to send the message
-				// through to the game controller.
-				s.game.RecieveMessage(event.Player, event.Message)
+	switch msg := event.Message.(type) {
+	case TickMessage:
+		s.tick(time.Duration(msg.Tick) * time.Millisecond)
+		s.expireDisconnected()
+		for _, p := range s.players {
+			s.updatePresence(p)
+		}
+	case JoinMessage:
+		new := true
+		for _, x := range s.players {
+			if event.Player == x {
+				new = false
+				break
 			}
-		default:
-			s.game.RecieveMessage(event.Player, event.Message)
 		}
+
+		if new {
+			// On the first pass, set up the player and begin handling
+			// their messages for them.
+			s.addPlayer(event.Player, NewJoinMessage())
+		} else {
+			// On subsequent passes, we just want to send the message
+			// through to the game controller.
+			s.deliver(event.Player, event.Message)
+		}
+	case ResumeMessage:
+		s.resumePlayer(event.Player, msg.conn)
+	case DisconnectMessage:
+		s.disconnectPlayer(event.Player, msg.conn)
+	case ErrorMessage:
+		// The player sent something we couldn't decode. It never
+		// reaches the game, so neither it nor the reply are
+		// journaled.
+		event.Player.write(msg)
+	default:
+		event.Player.lastHeard = s.game.GetTime()
+		s.deliver(event.Player, event.Message)
+		s.updatePresence(event.Player)
 	}
 }
 
@@ -160,20 +529,46 @@ func (s *GameServer) HandleMessages() {
 func (s *GameServer) RunClock() {
 	ticks := 0 * time.Second
 	for {
-		time.Sleep(TickInterval)
+		select {
+		case <-s.done:
+			return
+		case <-s.clock.After(TickInterval):
+		}
 		ticks += TickInterval
-		s.incomingMessages <- NewEvent(nil, NewTickMessage(ticks))
+
+		select {
+		case <-s.done:
+			return
+		case s.incomingMessages <- NewEvent(nil, NewTickMessage(ticks)):
+		}
 	}
 }
 
 // NewGameServer constructs a game server object, initializes the threads which it
 // needs to handle messages and the game clock.
-func NewGameServer(name string) *GameServer {
+func NewGameServer(name string, seed int64, config GameConfig) *GameServer {
+	return NewGameServerWithClock(name, seed, config, realClock{})
+}
+
+// NewGameServerWithClock is like NewGameServer, but takes its sense of time
+// from the given clock.
+func NewGameServerWithClock(name string, seed int64, config GameConfig, clock Clock) *GameServer {
 	g := GameServer{
 		game:             nil,
+		clock:            clock,
 		incomingMessages: make(chan Event),
+		done:             make(chan struct{}),
+		lastActive:       clock.Now(),
+		disconnected:     map[*Player]time.Duration{},
+	}
+	if JournalDir != "" {
+		journal, err := OpenJournal(JournalDir, name, seed, config)
+		if err != nil {
+			log.Printf("Unable to journal game %q: %v", name, err)
+		}
+		g.journal = journal
 	}
-	g.game = NewGame(name, &g)
+	g.game = NewGameWithConfig(name, &g, seed, config)
 
 	go g.HandleMessages()
 	go g.RunClock()
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer constructs a game server without its clock and game threads,
+// so tests can drive it directly from the test's thread.
+func newTestServer(t *testing.T) *GameServer {
+	s := &GameServer{
+		clock:            realClock{},
+		incomingMessages: make(chan Event),
+		done:             make(chan struct{}),
+		disconnected:     map[*Player]time.Duration{},
+	}
+	s.game = NewGame("test", s, 1)
+	t.Cleanup(func() {
+		s.Stop()
+		for _, p := range s.players {
+			p.outbox.Close()
+		}
+	})
+	return s
+}
+
+// connectPlayer adds a player to the game on a new connection, and returns
+// them with the client's end of the connection.
+func connectPlayer(t *testing.T, s *GameServer, name string) (*Player, *websocket.Conn) {
+	t.Helper()
+	conn, client := connectTestSocket(t)
+	p := &Player{name: name, session: "session-" + name, Connection: conn, alive: true, connected: true}
+	s.addPlayer(p, nil)
+	s.deliver(p, NewJoinMessage())
+	return p, client
+}
+
+// handleNext waits for the next event the player queues for the game thread,
+// and handles it. Events from other players, e.g. old connections dropping,
+// are skipped.
+func (s *GameServer) handleNext(t *testing.T, p *Player) {
+	t.Helper()
+	for {
+		select {
+		case event := <-s.incomingMessages:
+			if event.Player == p {
+				s.handle(event)
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s never queued an event for the game", p.Name())
+		}
+	}
+}
+
+// readAction reads from a client until it is sent the given action.
+func readAction(t *testing.T, client *websocket.Conn, action MessageAction) {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg struct {
+			Action string `json:"action"`
+		}
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("never sent %q: %v", action, err)
+		}
+		if json.Unmarshal(data, &msg) == nil && msg.Action == string(action) {
+			return
+		}
+	}
+}
+
+func TestClientJoiningAgainIsCaughtUp(t *testing.T) {
+	s := newTestServer(t)
+	alice, client := connectPlayer(t, s, "alice")
+	s.game.Health[alice] = 1
+
+	client.WriteJSON(NewJoinMessage())
+	s.handleNext(t, alice)
+	if got := len(s.players); got != 1 {
+		t.Errorf("server has %d players after alice joined again, want 1", got)
+	}
+	if got := len(s.game.Users()); got != 1 {
+		t.Errorf("game has %d users after alice joined again, want 1", got)
+	}
+	if got := s.game.Health[alice]; got != 1 {
+		t.Errorf("health = %d after joining again, want 1", got)
+	}
+}
+
+func TestResumeRebindsConnection(t *testing.T) {
+	s := newTestServer(t)
+	alice, _ := connectPlayer(t, s, "alice")
+	old := alice.Connection
+	s.disconnectPlayer(alice, old)
+	if alice.connected {
+		t.Fatalf("alice is still connected")
+	}
+
+	conn, client := connectTestSocket(t)
+	s.resumePlayer(&Player{name: "alice", session: alice.session, Connection: conn}, conn)
+	if !alice.connected || alice.Connection != conn {
+		t.Errorf("alice wasn't given the new connection")
+	}
+	if _, ok := s.disconnected[alice]; ok {
+		t.Errorf("alice is still waiting to resume")
+	}
+	if got := len(s.game.Users()); got != 1 {
+		t.Errorf("game has %d users after resuming, want 1", got)
+	}
+	readAction(t, client, WelcomeAction)
+
+	// The old connection dropping late doesn't affect the new one.
+	s.disconnectPlayer(alice, old)
+	if !alice.connected {
+		t.Errorf("alice was disconnected by their old connection")
+	}
+}
+
+func TestResumeWithWrongTokenJoins(t *testing.T) {
+	s := newTestServer(t)
+	alice, _ := connectPlayer(t, s, "alice")
+	s.disconnectPlayer(alice, alice.Connection)
+
+	conn, _ := connectTestSocket(t)
+	stranger := &Player{name: "mallory", session: "wrong", Connection: conn, alive: true, connected: true}
+	s.resumePlayer(stranger, conn)
+	s.handleNext(t, stranger)
+
+	if alice.connected || alice.Connection == conn {
+		t.Errorf("a wrong token took over alice's place")
+	}
+	if stranger.session == "wrong" {
+		t.Errorf("the stranger joined with the token they chose")
+	}
+	if got := len(s.game.Users()); got != 2 {
+		t.Errorf("game has %d users, want the stranger to join as well as alice", got)
+	}
+}
+
+func TestDisconnectedPlayerExpires(t *testing.T) {
+	s := newTestServer(t)
+	alice, _ := connectPlayer(t, s, "alice")
+	connectPlayer(t, s, "bob")
+	s.disconnectPlayer(alice, alice.Connection)
+
+	s.tick(ReconnectGracePeriod - TickInterval)
+	s.expireDisconnected()
+	if got := len(s.game.Users()); got != 2 {
+		t.Fatalf("alice left during the grace period")
+	}
+
+	s.tick(ReconnectGracePeriod)
+	s.expireDisconnected()
+	if got := len(s.game.Users()); got != 1 {
+		t.Errorf("game has %d users after the grace period, want 1", got)
+	}
+	for _, p := range s.players {
+		if p == alice {
+			t.Errorf("alice is still a player after the grace period")
+		}
+	}
+	if s.Stopped() {
+		t.Errorf("game stopped while bob is still playing")
+	}
+}
+
+func TestResumeAfterExpiryJoinsAgain(t *testing.T) {
+	s := newTestServer(t)
+	alice, _ := connectPlayer(t, s, "alice")
+	connectPlayer(t, s, "bob")
+	s.disconnectPlayer(alice, alice.Connection)
+	s.tick(ReconnectGracePeriod)
+	s.expireDisconnected()
+
+	conn, _ := connectTestSocket(t)
+	returning := &Player{name: "alice", session: alice.session, Connection: conn, alive: true, connected: true}
+	s.resumePlayer(returning, conn)
+	s.handleNext(t, returning)
+
+	if alice.connected {
+		t.Errorf("an expired session was resumed")
+	}
+	if got := s.game.Health[returning]; got != s.game.Config.MaxHealth {
+		t.Errorf("health = %d, want alice to start over", got)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 )
 
 type SiteEvent interface {
@@ -17,6 +16,12 @@ type SiteEvent interface {
 	End(*Game, User, EventResponseMessage) *EventMessage
 }
 
+// A responseChecker is a SiteEvent which checks a response is valid before
+// anything is spent on it.
+type responseChecker interface {
+	CheckResponse(*Game, User, EventResponseMessage) error
+}
+
 type RepairSite struct{}
 
 func NewRepairSite() RepairSite {
@@ -36,7 +41,7 @@ func (e RepairSite) Begin(g *Game, u User) EventMessage {
 }
 func (e RepairSite) End(g *Game, u User, r EventResponseMessage) *EventMessage {
 	site := g.UserSites[u]
-	g.SiteRepairState[site] += uint64(r.ResourceAmount)
+	g.ModifyRepair(site, r.ResourceAmount*g.Config.RepairPerLog)
 
 	if r.ResourceAmount > 0 {
 		title := fmt.Sprintf("Repaired %s.", site)
@@ -58,49 +63,58 @@ func NewGetResource() GetResource {
 }
 
 func (e GetResource) Mods(g *Game, u User) int {
-	// Only some sites can support picking up items.
+	// Only some sites can support picking up items, and there is less to
+	// find at broken down sites.
 	site := g.UserSites[u]
 
 	switch site {
 	case Forest:
-		return 500
+		return repairWeight(g, site, 500, false)
 	case Farm:
-		return 500
+		return repairWeight(g, site, 500, false)
 	case Hospital:
-		return 500
+		return repairWeight(g, site, 500, false)
 	case Watchtower:
-		return 500
+		return repairWeight(g, site, 500, false)
 	}
 
 	return 0
 }
 
+func (e GetResource) Rules() GenerationRules {
+	return GenerationRules{ID: "get_resource", Rarity: Common}
+}
+
 func (e GetResource) Begin(g *Game, u User) EventMessage {
-	site := g.UserSites[u]
+	depletion := g.Depletion[g.UserSites[u]]
+	resource, amount := g.Harvest(u)
 
-	resource := Log
 	term := "logs"
-
-	switch site {
-	case Forest:
-		resource = Log
+	switch resource {
+	case Log:
 		term = "logs"
-	case Farm:
-		resource = Food
+	case Food:
 		term = "food"
-	case Hospital:
-		resource = Bandage
+	case Bandage:
 		term = "bandages"
-	case Watchtower:
-		resource = Bullet
+	case Bullet:
 		term = "bullets"
 	}
 
+	if amount == 0 {
+		title := fmt.Sprintf("Looking for %s", term)
+		description := "You only found scraps, but you keep hold of them."
+		return NewEventMessage(title, description)
+	}
+
 	title := fmt.Sprintf("Found some %s", term)
 	description := "You are lucky"
+	if depletion >= MaxDepletion/2 {
+		description = "Somebody has picked this place over, but you find a little."
+	}
 
 	msg := NewEventMessage(title, description)
-	msg.WithResourceYield("Pick up", resource, 1)
+	msg.WithResourceYield("Pick up", resource, amount)
 	return msg
 }
 
@@ -108,24 +122,39 @@ func (e GetResource) End(g *Game, u User, r EventResponseMessage) *EventMessage
 	return nil
 }
 
-type Attack struct{}
+// An Attack is an animal attacking a user. A weakened attack has already
+// been wounded from the watchtower, so it can't do as much harm.
+type Attack struct {
+	weakened bool
+}
 
 func NewAttack() Attack {
 	return Attack{}
 }
 
+func NewWeakenedAttack() Attack {
+	return Attack{weakened: true}
+}
+
+// Rules allow at most one attack on each user per visit.
+func (e Attack) Rules() GenerationRules {
+	return GenerationRules{ID: "attack", Rarity: Common, PlayerCooldown: 1}
+}
+
+// Mods makes attacks more likely at broken down sites, since the animals can
+// get in more easily.
 func (e Attack) Mods(g *Game, u User) int {
 	site := g.UserSites[u]
 
 	switch site {
 	case Forest:
-		return 100
+		return repairWeight(g, site, 100, true)
 	case Farm:
-		return 100
+		return repairWeight(g, site, 100, true)
 	case Hospital:
-		return 100
+		return repairWeight(g, site, 100, true)
 	case Watchtower:
-		return 50
+		return repairWeight(g, site, 50, true)
 	}
 
 	return 0
@@ -146,6 +175,9 @@ func (e Attack) Begin(g *Game, u User) EventMessage {
 	}
 
 	description := fmt.Sprintf("You have a chance to shoot it, if you have any bullets!")
+	if e.weakened {
+		description = "It's already wounded. You can finish it off, if you have any bullets!"
+	}
 	msg := NewEventMessage(title, description)
 	msg.HasSubsequentStatusUpdate = true
 	msg.WithSpendButton(Bullet)
@@ -154,18 +186,40 @@ func (e Attack) Begin(g *Game, u User) EventMessage {
 }
 
 func (e Attack) End(g *Game, u User, r EventResponseMessage) *EventMessage {
-	msg := NewEventMessage("The animal bites you!", "It's very painful!")
-	msg.HealthModifier = -1
-
 	if r.ResourceAmount > 0 {
-		msg.Title = fmt.Sprintf("You shot the animal.")
-		msg.Description = fmt.Sprintf("In an act of heroic bravery, you shot the animal and saved yourself")
-		msg.HealthModifier = 0
+		msg := NewEventMessage("You shot the animal.", "In an act of heroic bravery, you shot the animal and saved yourself")
+		return &msg
+	}
+
+	msg := NewEventMessage("The animal bites you!", "It's very painful, and you're bleeding!")
+	msg.HealthModifier = -1
+	if e.weakened {
+		// It's too weak to bite deep.
+		msg.Description = "It hurts, but at least it isn't deep."
+		return &msg
 	}
 
+	// Bites keep bleeding until they're bandaged.
+	g.AddStatus(u, Bleeding)
 	return &msg
 }
 
+// NothingHappens fills a round for a user who has no events left, so the visit
+// still lasts NumSiteVisitRounds.
+type NothingHappens struct{}
+
+func NewNothingHappens() NothingHappens {
+	return NothingHappens{}
+}
+
+func (e NothingHappens) Mods(g *Game, u User) int { return 0 }
+func (e NothingHappens) Begin(g *Game, u User) EventMessage {
+	return NewEventMessage("Nothing happens", "The hours pass quietly.")
+}
+func (e NothingHappens) End(g *Game, u User, r EventResponseMessage) *EventMessage {
+	return nil
+}
+
 type GotoBeach struct{}
 
 func NewGotoBeach() GotoBeach {
@@ -180,60 +234,33 @@ func (e GotoBeach) Begin(g *Game, u User) EventMessage {
 	msg.HasSubsequentStatusUpdate = true
 	return msg
 }
-func (e GotoBeach) End(g *Game, u User, r EventResponseMessage) *EventMessage {
-	switch s := g.state.(type) {
-	case *SiteVisitController:
-		// [hack] recomputing for every users
-		totalNumBeachGoers := len(s.goBeachResponses)
-		totalResources := map[CommodityType]int{
-			Log: 0, Food: 0, Bandage: 0, Bullet: 0,
-		}
-		for _, inventory := range s.goBeachResponses {
-			for commodityType, count := range inventory {
-				totalResources[commodityType] += count
-			}
-		}
-		required := ResourceRequiredToLeave(totalNumBeachGoers)
-		leaveSuccess := true
-		for commodityType, count := range totalResources {
-			if count < required[commodityType] {
-				leaveSuccess = false
-			}
-		}
-		title := fmt.Sprintf("Haha, you are stuck for now. Not enough resources")
-		description := "Now you gotta go back and face the group"
-		if leaveSuccess {
-			title = fmt.Sprintf("Together, you have enough resources to leave the island!")
-			description = "LEEEAVE NOW!"
-		}
-		msg := NewEventMessage(title, description)
-		return &msg
 
-	default:
-		panic(fmt.Sprintf("GotoBeach event ending during %v", s))
-	}
+// End doesn't decide anything, since everyone at the beach builds the raft
+// together once they have all said what they brought. See settleBeach.
+func (e GotoBeach) End(g *Game, u User, r EventResponseMessage) *EventMessage {
+	return nil
 }
 
+// ObserveAttack is an attack on another site, seen from the watchtower.
+// Everyone at the watchtower gets the same one, and decides how many bullets
+// to fire at it.
 type ObserveAttack struct {
-	site Site
+	defense *Defense
 }
 
-func NewObserveAttack() ObserveAttack {
-	sites := []Site{Forest, Farm, Hospital}
-	choice := rand.Intn(len(sites))
-
+func NewObserveAttack(defense *Defense) ObserveAttack {
 	return ObserveAttack{
-		site: sites[choice],
+		defense: defense,
 	}
 }
 
 func (e ObserveAttack) Mods(g *Game, u User) int {
-	return 500
+	return ObservedAttackChance
 }
 
 func (e ObserveAttack) Begin(g *Game, u User) EventMessage {
 	title := "Animals on the move!"
-	description := fmt.Sprintf("Angry animals are moving toward the %s. You can shoot them, if you have bullets.", e.site)
+	description := fmt.Sprintf("Angry animals are moving toward the %s. You can shoot them, if you have bullets.", e.defense.site)
 	msg := NewEventMessage(title, description)
 	msg.WithSpendButton(Bullet)
 	msg.HasSubsequentStatusUpdate = true
@@ -241,46 +268,30 @@ func (e ObserveAttack) Begin(g *Game, u User) EventMessage {
 	return msg
 }
 
+// End records the user's shots. The attack is settled once everyone at the
+// watchtower has answered.
 func (e ObserveAttack) End(g *Game, u User, r EventResponseMessage) *EventMessage {
+	e.defense.Fire(u, r.ResourceAmount)
+
 	if r.ResourceAmount > 0 {
-		msg := NewEventMessage("You shot the animals!", "They ran away scared.")
+		msg := NewEventMessage("You open fire!", fmt.Sprintf("You fire %d bullets at the animals heading for the %s.", r.ResourceAmount, e.defense.site))
 		return &msg
 	}
 
-	// Send a defense failed message to the game.
-	g.RecieveMessage(u, NewDefenseFailedMessage(e.site))
-
-	title := fmt.Sprintf("The animals go straight for the %s!", e.site)
-	description := "They look really angry!"
+	title := fmt.Sprintf("The animals head for the %s.", e.defense.site)
+	description := "You hold your fire, and hope someone else doesn't."
 	msg := NewEventMessage(title, description)
 
 	return &msg
 }
 
-func GenerateObservedAttack(g *Game, u User) *ObserveAttack {
-	event := NewObserveAttack()
-	choice := rand.Intn(1000)
-	if choice < event.Mods(g, u) {
-		return &event
-	}
-
-	return nil
-}
-
-func GenerateEvent(g *Game, u User) *SiteEvent {
-	allEvents := []SiteEvent{
-		NewGetResource(),
-		NewAttack(),
-	}
-
-	choice := rand.Intn(1000)
-	count := 0
-	for _, event := range allEvents {
-		count += event.Mods(g, u)
-		if choice < count {
-			return &event
-		}
+// GenerateObservedAttack decides whether there is an attack to be seen from
+// the watchtower, and which site it is heading for.
+func GenerateObservedAttack(g *Game) (Site, bool) {
+	sites := []Site{Forest, Farm, Hospital}
+	site := sites[g.rng.Intn(len(sites))]
+	if g.rng.Intn(1000) < ObservedAttackChance {
+		return site, true
 	}
-
-	return nil
+	return NoSiteSelected, false
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// Snapshot describes the whole game as the user sees it, so a client can
+// catch up after joining late or reloading.
+func (g *Game) Snapshot(u User) StateSnapshotMessage {
+	var players []PlayerStatus
+	for _, p := range g.Users() {
+		players = append(players, PlayerStatus{
+			Name:     p.Name(),
+			Alive:    g.Health[p] > 0,
+			Escaped:  g.Escaped[p],
+			Presence: g.Presence[p],
+		})
+	}
+
+	repair := map[Site]uint64{}
+	for site, state := range g.SiteRepairState {
+		repair[site] = state
+	}
+
+	snapshot := StateSnapshotMessage{
+		Action:          string(StateSnapshotAction),
+		State:           string(g.state.Name()),
+		Time:            int(g.TimeRemaining() / time.Millisecond),
+		Day:             g.round,
+		NumDays:         g.Config.NumDays,
+		Players:         players,
+		SiteRepairState: repair,
+		Site:            g.UserSites[u],
+		Inventory:       g.Inventory(u).Copy(),
+		Health:          g.Health[u],
+		Statuses:        g.StatusEffects(u),
+		MaxHealth:       g.Config.MaxHealth,
+	}
+	if s, ok := g.state.(*SiteVisitController); ok {
+		if event, ok := s.ActiveEvent(u); ok {
+			snapshot.Event = &event
+		}
+	}
+	return snapshot
+}
+
+// SendSnapshot sends the user a snapshot of the game.
+func (g *Game) SendSnapshot(u User) {
+	u.Message(g.Snapshot(u))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSnapshotSentOnJoin(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+
+	snapshot := lastMessage[StateSnapshotMessage](t, alice.messages, nil)
+	if snapshot.State != string(WaitingState) || snapshot.Health != g.Config.MaxHealth {
+		t.Errorf("snapshot = %+v, want a healthy player waiting", snapshot)
+	}
+	if len(snapshot.Players) != 1 || snapshot.Players[0].Name != "alice" || !snapshot.Players[0].Alive {
+		t.Errorf("players = %+v, want alice", snapshot.Players)
+	}
+}
+
+func TestSnapshotIncludesActiveEvent(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	startVisit(t, g, alice, Forest)
+	event := alice.lastEvent(t)
+
+	g.RecieveMessage(alice, NewResumeMessage(nil))
+	snapshot := lastMessage[StateSnapshotMessage](t, alice.messages, nil)
+	if snapshot.State != string(SiteVisitState) || snapshot.Site != Forest || snapshot.Day != 1 {
+		t.Errorf("snapshot = %+v, want day 1 in the forest", snapshot)
+	}
+	if snapshot.Event == nil || snapshot.Event.MessageID != event.MessageID {
+		t.Errorf("snapshot event = %+v, want %+v", snapshot.Event, event)
+	}
+	if snapshot.Time <= 0 {
+		t.Errorf("snapshot time = %d, want time left in the round", snapshot.Time)
+	}
+
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, true, false, 0))
+	g.RecieveMessage(alice, NewRequestSnapshotMessage())
+	if snapshot := lastMessage[StateSnapshotMessage](t, alice.messages, nil); snapshot.Event != nil {
+		t.Errorf("snapshot event = %+v after responding, want none", snapshot.Event)
+	}
+}
@@ -13,23 +13,33 @@ const (
 	WaitingState       GameState = "waiting"
 	SiteSelectionState GameState = "site_selection"
 	SiteVisitState     GameState = "site_visit"
+	UpkeepState        GameState = "upkeep"
+	GameOverState      GameState = "game_over"
 )
 
+// The defaults for the rules in GameConfig.
 const (
 	// MinPlayers sets the minimum number of players required before the game
 	// will proceed past the Waiting stage.
 	MinPlayers int = 1
 
-	MaxEventsPerRound int = 3
+	// MaxObservedAttacks is how many attacks can be seen from the watchtower
+	// during a site visit.
+	MaxObservedAttacks int = 3
 
-	// Amount of time to wait while site is selected.
-	SiteSelectionDuration time.Duration = 2 * time.Second
-	// Number of rounds during the site visit.
+	// Amount of time to wait while site is selected. Anyone who hasn't
+	// chosen a site by then is sent to a random one.
+	SiteSelectionDuration time.Duration = 30 * time.Second
+	// Number of event rounds during the site visit.
 	NumSiteVisitRounds int = 5
 	// Length of each round
 	SiteVisitRoundDuration time.Duration = 6 * time.Second
 	// Time allocated for status updates, if any
 	SiteVisitStatusDuration time.Duration = 4 * time.Second
+
+	// DefaultNumDays is how many days the game lasts. Each day is a site
+	// selection followed by a site visit, and upkeep before the next day.
+	DefaultNumDays int = 5
 )
 
 type StateController interface {
@@ -76,6 +86,10 @@ func (s *WaitingController) RecieveMessage(u User, m Message) {
 		s.ready[u] = false
 	case LeaveMessage:
 		delete(s.ready, u)
+	case ResumeMessage, PresenceMessage:
+		// Just send a playerinfo update (done below), so the
+		// returning user can see who is ready, and everyone can see
+		// who is still around.
 	case SetNameMessage:
 		// Just send a playerinfo update (done below),
 		// no need to take action, since
@@ -86,14 +100,7 @@ func (s *WaitingController) RecieveMessage(u User, m Message) {
 
 	// Inform all of the clients of the ready state of the other
 	// clients.
-	var info []PlayerInfo
-	for u, ready := range s.ready {
-		info = append(info, PlayerInfo{
-			Name:  u.Name(),
-			Ready: ready,
-		})
-	}
-	s.game.connection.Broadcast(NewPlayerInfoUpdateMessage(info))
+	s.game.connection.Broadcast(NewPlayerInfoUpdateMessage(s.game.PlayerInfo(s.ready)))
 	s.proceedIfReady()
 }
 
@@ -106,7 +113,7 @@ func (s *WaitingController) proceedIfReady() {
 		count++
 	}
 
-	if count >= s.game.MinPlayers {
+	if count >= s.game.Config.MinPlayers {
 		s.game.ChangeState(SiteSelectionState)
 	}
 }
@@ -124,7 +131,7 @@ func NewSiteSelectionController(game *Game) *SiteSelectionController {
 
 	return &SiteSelectionController{
 		game: game,
-		name: WaitingState,
+		name: SiteSelectionState,
 	}
 }
 
@@ -132,34 +139,70 @@ func NewSiteSelectionController(game *Game) *SiteSelectionController {
 func (s *SiteSelectionController) Name() GameState { return s.name }
 
 // Begin is called when the state becomes active.
-func (s *SiteSelectionController) Begin() {}
+func (s *SiteSelectionController) Begin() {
+	s.game.SetTimeout(s.game.Config.SiteSelectionDuration)
+	s.game.connection.Broadcast(NewSetClockMessage(s.game.Config.SiteSelectionDuration))
+}
 
 // End is called when the state is no longer active.
 func (s *SiteSelectionController) End() {}
 
-// Timer is called when a timeout occurs.
-func (s *SiteSelectionController) Timer(tick time.Duration) {}
+// Timer is called when a timeout occurs. Anyone still choosing is sent to a
+// random site, so one idle player can't hold up everyone else.
+func (s *SiteSelectionController) Timer(tick time.Duration) {
+	s.proceed()
+}
+
+// proceed sends anyone who hasn't chosen a site to a random one, and starts
+// the visit.
+func (s *SiteSelectionController) proceed() {
+	for _, u := range s.game.Users() {
+		if s.game.UserSites[u] != NoSiteSelected || !s.game.IsAlive(u) {
+			continue
+		}
+
+		// Nobody is sent to the beach, since they might not be ready
+		// to leave.
+		sites := AllSites()
+		site := sites[s.game.rng.Intn(len(sites)-1)]
+		log.Printf("User[name=%v] didn't choose a site, sending them to %q", u.Name(), site)
+		s.game.UserSites[u] = site
+		u.Message(NewSiteSelectionMessage(site))
+	}
+	s.game.ChangeState(SiteVisitState)
+}
 
 // RecieveMessage is called when a user sends a message to the server.
 func (s *SiteSelectionController) RecieveMessage(u User, m Message) {
 	switch msg := m.(type) {
+	case ResumeMessage:
+		u.Message(NewSetClockMessage(s.game.TimeRemaining()))
+		return
 	case SiteSelectionMessage:
+		if !isSite(msg.SiteSelected) {
+			s.game.reject(u, MalformedPayloadError, "there is no site %q", msg.SiteSelected)
+			return
+		}
 		s.game.UserSites[u] = msg.SiteSelected
+	case PresenceMessage:
+		// Someone might have been waiting on the player who left.
 	default:
 		return
 	}
 
-	// Since a site was selected, check if everyone picked a site. If so, we can proceed.
+	// Check if everyone picked a site. If so, we can proceed. Players whose
+	// connection was lost don't hold anyone up, and are sent somewhere
+	// random.
 	ready := true
-	for u, s := range s.game.UserSites {
-		if s == NoSiteSelected {
+	for u, site := range s.game.UserSites {
+		if site == NoSiteSelected && s.game.IsAlive(u) && s.game.Presence[u] != PresenceLost {
 			ready = false
 		}
-		fmt.Printf("user %q chose %q", u.Name(), s)
+		fmt.Printf("user %q chose %q", u.Name(), site)
 	}
 
 	if ready {
-		s.game.ChangeState(SiteVisitState)
+		s.proceed()
 	}
 }
 
@@ -167,24 +210,43 @@ type SiteVisitController struct {
 	game *Game
 	name GameState
 
-	userEventQueue      map[User][]SiteEvent
-	nextMessageID       uint64
-	messageHandlers     map[uint64]SiteEvent
+	// round counts the event rounds so far.
+	round int
+
+	userEventQueue  map[User][]SiteEvent
+	messageHandlers map[uint64]SiteEvent
+	sentMessages    map[uint64]EventMessage
+
+	// The user each event was sent to, and the game time by which they
+	// have to respond. These are kept after the event is resolved, so
+	// that repeated responses can be recognized.
+	eventOwners         map[uint64]User
+	eventDeadlines      map[uint64]time.Duration
 	eventFinishHandlers map[User]uint64
+	activeEvents        map[User]uint64
 
 	statusPhase bool
 
+	// The goods each user brought to the beach, and whether the raft has
+	// been tried yet. It's tried once, by everyone at the beach together.
 	goBeachResponses map[User]map[CommodityType]int
+	beachSettled     bool
+
+	// Attacks seen from the watchtower which haven't been settled yet.
+	defenses []*Defense
 }
 
 func NewSiteVisitController(game *Game) *SiteVisitController {
 	return &SiteVisitController{
 		game:                game,
-		name:                WaitingState,
+		name:                SiteVisitState,
 		userEventQueue:      map[User][]SiteEvent{},
-		nextMessageID:       0,
 		messageHandlers:     map[uint64]SiteEvent{},
+		sentMessages:        map[uint64]EventMessage{},
+		eventOwners:         map[uint64]User{},
+		eventDeadlines:      map[uint64]time.Duration{},
 		eventFinishHandlers: map[User]uint64{},
+		activeEvents:        map[User]uint64{},
 		goBeachResponses:    map[User]map[CommodityType]int{},
 	}
 }
@@ -192,14 +254,21 @@ func NewSiteVisitController(game *Game) *SiteVisitController {
 // Name returns the name of the current state.
 func (s *SiteVisitController) Name() GameState { return s.name }
 
-func ShuffleQueue(e []SiteEvent) {
-	rand.Shuffle(len(e), func(i, j int) { e[i], e[j] = e[j], e[i] })
+func ShuffleQueue(rng *rand.Rand, e []SiteEvent) {
+	rng.Shuffle(len(e), func(i, j int) { e[i], e[j] = e[j], e[i] })
 }
 
 // Begin is called when the state becomes active.
 func (s *SiteVisitController) Begin() {
+	s.game.round++
+
 	// For sites other than beach, fill up the queues with random events.
-	for user, site := range s.game.UserSites {
+	for _, user := range s.game.Users() {
+		site := s.game.UserSites[user]
+		if !s.game.IsAlive(user) {
+			continue
+		}
+
 		switch site {
 		case Beach:
 			event := NewGotoBeach()
@@ -208,69 +277,84 @@ func (s *SiteVisitController) Begin() {
 				event,
 			)
 		default:
-			for i := 0; i < MaxEventsPerRound; i++ {
-				event := GenerateEvent(s.game, user)
-				if event == nil {
-					continue
-				}
-
-				s.userEventQueue[user] = append(
-					s.userEventQueue[user],
-					*event,
-				)
-			}
+			s.userEventQueue[user] = append(
+				s.userEventQueue[user],
+				s.game.events.Generate(user)...,
+			)
 		}
 	}
 
-	// The observed attack mechanism is handled here. All visitors at the
-	// watchtower will get the observed attack message (and if no user is
-	// there, the attacks will automatically proceed without defense).
-	for i := 0; i < MaxEventsPerRound; i++ {
-		event := GenerateObservedAttack(s.game, NewPlayer())
-		if event == nil {
-			continue
-		}
-
-		// We got an observed attack. If there are observers at the
-		// watchtower, let one of them defend.
-		possibleDefenders := []User{}
-		for user, site := range s.game.UserSites {
-			if site == Watchtower {
-				possibleDefenders = append(possibleDefenders, user)
-			}
-		}
+	// Shuffle all user event queues to make them seem more natural. This
+	// happens before any attacks are added, so they stay at the front.
+	for _, user := range s.game.Users() {
+		ShuffleQueue(s.game.rng, s.userEventQueue[user])
+	}
 
-		// If there are no observers, add attacks to the users at that
-		// site.
-		if len(possibleDefenders) == 0 {
-			for user, site := range s.game.UserSites {
-				if site == event.site {
-					s.userEventQueue[user] = append(s.userEventQueue[user], NewAttack())
-				}
-			}
-		} else {
-			// There are defenders. Choose one defender and let them defend it.
-			defender := possibleDefenders[rand.Intn(len(possibleDefenders))]
-			s.userEventQueue[defender] = append(s.userEventQueue[defender], event)
+	// The observed attack mechanism is handled here. Everyone at the
+	// watchtower gets each observed attack, in the same round, so they
+	// can defend the site together. If nobody is there, or the
+	// watchtower is too broken down to spot the attack, it goes ahead
+	// without a defense. There's always room left for the repair and
+	// treatment events.
+	defenders := []User{}
+	for _, user := range s.game.Users() {
+		if s.game.UserSites[user] == Watchtower && s.game.IsAlive(user) {
+			defenders = append(defenders, user)
 		}
 	}
+	observed := []SiteEvent{}
+	maxAttacks := s.game.Config.MaxObservedAttacks
+	if maxAttacks > s.game.Config.NumSiteVisitRounds-2 {
+		maxAttacks = s.game.Config.NumSiteVisitRounds - 2
+	}
+	for i := 0; i < maxAttacks; i++ {
+		site, ok := GenerateObservedAttack(s.game)
+		if !ok {
+			continue
+		}
 
-	// Shuffle all user event queues to make them seem more natural.
-	for user, _ := range s.game.UserSites {
-		ShuffleQueue(s.userEventQueue[user])
+		if len(defenders) == 0 || !s.game.RepairWorks(Watchtower) {
+			s.resolveDefense(NewDefense(site, nil))
+			continue
+		}
+		defense := NewDefense(site, defenders)
+		s.defenses = append(s.defenses, defense)
+		observed = append(observed, NewObserveAttack(defense))
 	}
 
-	// Prepend the repair event to the user queue.
-	for user, site := range s.game.UserSites {
+	// Prepend the repair event, and any observed attacks, to the user
+	// queue.
+	for _, user := range s.game.Users() {
+		site := s.game.UserSites[user]
 		// skip beach
-		if site == Beach {
+		if site == Beach || !s.game.IsAlive(user) {
 			continue
 		}
 
-		s.userEventQueue[user] = append(
-			[]SiteEvent{NewRepairSite()},
-			s.userEventQueue[user]...,
-		)
+		first := []SiteEvent{NewRepairSite()}
+		if site == Watchtower {
+			first = append(first, observed...)
+		}
+
+		// There's one event per round, so drop whatever won't fit
+		// alongside the repair, attack and treatment events. Attacks
+		// which weren't defended are at the front of the queue, and
+		// there are few enough of them that they always fit.
+		room := s.game.Config.NumSiteVisitRounds - len(first)
+		injured := s.game.NeedsTreatment(user)
+		if injured {
+			room--
+		}
+		if len(s.userEventQueue[user]) > room {
+			s.userEventQueue[user] = s.userEventQueue[user][:room]
+		}
+
+		s.userEventQueue[user] = append(first, s.userEventQueue[user]...)
+
+		// Injured users get a chance to patch themselves up at the end.
+		if injured {
+			s.userEventQueue[user] = append(s.userEventQueue[user], NewTreatWounds())
+		}
 	}
 
 	// Give all the users their initial events.
@@ -288,74 +372,109 @@ func (s *SiteVisitController) GiveNewEvent(u User) bool {
 	// Pop the event out of the user's queue
 	event := s.userEventQueue[u][0]
 	s.userEventQueue[u] = s.userEventQueue[u][1:]
+	s.giveEvent(u, event)
+	return true
+}
 
+// giveEvent sends a user an event, and registers it to handle their response.
+func (s *SiteVisitController) giveEvent(u User, event SiteEvent) {
 	msg := event.Begin(s.game, u)
 
 	// Register the message handler
-	msg.MessageID = s.nextMessageID
-	s.nextMessageID += 1
+	msg.MessageID = s.game.NewEventID()
 	s.messageHandlers[msg.MessageID] = event
+	s.sentMessages[msg.MessageID] = msg
+	s.eventOwners[msg.MessageID] = u
+	s.activeEvents[u] = msg.MessageID
 
 	// If no subsequent follow-on message exists, the timeout
 	// is actually the sum of the round duration + status
-	timer := SiteVisitRoundDuration + SiteVisitStatusDuration
+	timer := s.game.Config.SiteVisitRoundDuration + s.game.Config.SiteVisitStatusDuration
 
 	if msg.HasSubsequentStatusUpdate {
 		s.eventFinishHandlers[u] = msg.MessageID
-		timer = SiteVisitRoundDuration
+		timer = s.game.Config.SiteVisitRoundDuration
 	}
+	s.eventDeadlines[msg.MessageID] = s.game.GetTime() + timer
 
 	// Inform the user how long this event will take to handle.
 	u.Message(NewSetClockMessage(timer))
 
 	// Send the message to the user.
 	u.Message(msg)
-
-	return true
 }
 
 // End is called when the state is no longer active.
 func (s *SiteVisitController) End() {}
 
 func (s *SiteVisitController) HandleStatusPhase() {
-	for u, i := range s.eventFinishHandlers {
+	for _, u := range s.game.Users() {
+		i, ok := s.eventFinishHandlers[u]
+		if !ok {
+			continue
+		}
 		responder, ok := s.messageHandlers[i]
 		// If the user already responded, the responder will have been
 		// deleted, and we don't need to take any action here.
 		if ok {
-			response := responder.End(s.game, u, EventResponseMessage{})
-			delete(s.messageHandlers, i)
-
 			// Some events have follow-on short status updates. If so,
 			// send the status update to the user immediately.
-			if response != nil {
-				u.Message(response)
-				u.Message(NewSetClockMessage(SiteVisitStatusDuration))
+			if s.resolveEvent(u, i, responder, EventResponseMessage{}) != nil {
+				u.Message(NewSetClockMessage(s.game.Config.SiteVisitStatusDuration))
 			}
 		}
 	}
-	s.game.SetTimeout(SiteVisitStatusDuration)
+	// Everyone has answered this round's events, so everyone at the beach
+	// has said what they brought, and any attacks seen from the watchtower
+	// can be settled.
+	s.settleBeach()
+	s.resolveDefenses()
+	s.game.SetTimeout(s.game.Config.SiteVisitStatusDuration)
 }
 
 func (s *SiteVisitController) HandleEventPhase() {
-	// Send everyone a new event
-	noEventsLeft := true
-	for user, _ := range s.game.UserSites {
-		if s.GiveNewEvent(user) {
-			noEventsLeft = false
-		}
+	if s.round == s.game.Config.NumSiteVisitRounds {
+		s.endVisit()
+		return
 	}
+	s.round++
 
-	if noEventsLeft {
-		s.game.ChangeState(SiteSelectionState)
-		return
+	// Send everyone a new event. The visit lasts the same number of rounds
+	// for everyone, so anyone whose queue has run out has a quiet round.
+	for _, user := range s.game.Users() {
+		if !s.game.IsAlive(user) {
+			continue
+		}
+		if !s.GiveNewEvent(user) {
+			s.giveEvent(user, NewNothingHappens())
+		}
 	}
 
 	// Set another timer.
-	s.game.SetTimeout(SiteVisitRoundDuration)
+	s.game.SetTimeout(s.game.Config.SiteVisitRoundDuration)
+}
+
+// endVisit moves on to upkeep before the next day, or ends the game after the
+// last one.
+func (s *SiteVisitController) endVisit() {
+	if s.game.round >= s.game.Config.NumDays {
+		s.game.ChangeState(GameOverState)
+		return
+	}
+	for _, u := range s.game.Users() {
+		s.returnBeachGoods(u)
+	}
+	s.game.DecayRepair()
+	s.game.RegenerateSites()
+	s.game.ChangeState(UpkeepState)
 }
 
 func (s *SiteVisitController) HandlePhase() {
+	if s.game.IsOver() {
+		s.game.ChangeState(GameOverState)
+		return
+	}
+
 	if s.statusPhase {
 		s.HandleStatusPhase()
 	} else {
@@ -364,65 +483,301 @@ func (s *SiteVisitController) HandlePhase() {
 	s.statusPhase = !s.statusPhase
 }
 
+// ActiveEvent returns the event the user has been sent, if they haven't
+// responded to it yet.
+func (s *SiteVisitController) ActiveEvent(u User) (EventMessage, bool) {
+	id, ok := s.activeEvents[u]
+	if !ok {
+		return EventMessage{}, false
+	}
+	event, ok := s.sentMessages[id]
+	return event, ok
+}
+
 // Timer is called when a timeout occurs.
 func (s *SiteVisitController) Timer(tick time.Duration) {
 	s.HandlePhase()
 }
 
-func ResourceRequiredToLeave(numPlayers int) map[CommodityType]int {
-	// [todo] fill in with real values
-	if numPlayers == 1 {
-		return map[CommodityType]int{
-			Log:  1,
-			Food: 0,
+// resolveEvent ends an event with the given response. If the event has a
+// follow-on status update, it is sent to the user and any change in health
+// it describes is applied.
+func (s *SiteVisitController) resolveEvent(u User, id uint64, responder SiteEvent, r EventResponseMessage) *EventMessage {
+	response := responder.End(s.game, u, r)
+	delete(s.messageHandlers, id)
+	delete(s.sentMessages, id)
+
+	if response != nil {
+		u.Message(response)
+		s.game.ModifyHealth(u, response.HealthModifier)
+	}
+	return response
+}
+
+// settleResponse debits or credits the user's inventory according to the
+// buttons that were offered in the event, and returns the response with any
+// amounts the event didn't ask for cleared. If the user doesn't own what
+// they are trying to spend, nothing changes and false is returned.
+func (s *SiteVisitController) settleResponse(u User, event EventMessage, r EventResponseMessage) (EventResponseMessage, bool) {
+	if r.ResourceAmount < 0 {
+		s.game.reject(u, MalformedPayloadError, "can't spend %d resources", r.ResourceAmount)
+		return r, false
+	}
+	if !event.HasSpendButton {
+		r.ResourceAmount = 0
+	}
+	if !event.HasActionButton {
+		r.ClickedAction = false
+	}
+
+	spend := map[CommodityType]int{}
+	gain := map[CommodityType]int{}
+	if r.ResourceAmount > 0 {
+		spend[event.SpendButtonResource] += r.ResourceAmount
+	}
+	if r.ClickedAction {
+		resource := CommodityType(event.ActionButtonResource)
+		if event.ActionButtonResourceAmount > 0 {
+			spend[resource] += event.ActionButtonResourceAmount
+		} else {
+			gain[resource] += -event.ActionButtonResourceAmount
 		}
-	} else {
-		return map[CommodityType]int{
-			Log:  0,
-			Food: 0,
+	}
+
+	inv := s.game.Inventory(u)
+	if !inv.Remove(spend) {
+		s.game.reject(u, InsufficientResourcesError, "tried to spend %v, but only has %v", spend, inv)
+		return r, false
+	}
+	inv.Add(gain)
+
+	if len(spend) > 0 || len(gain) > 0 {
+		s.game.SendInventory(u)
+	}
+	return r, true
+}
+
+// DefenseFailed is called when the watchtower failed to defend an attack. So
+// it will propagate to the recipients of the attack, and damage the site.
+func (s *SiteVisitController) DefenseFailed(site Site) {
+	s.attackSite(site, NewAttack(), s.game.Config.AttackRepairDamage)
+}
+
+// attackSite damages a site, and sends the attack to everyone there.
+func (s *SiteVisitController) attackSite(site Site, attack Attack, damage int) {
+	s.game.ModifyRepair(site, -damage)
+	for _, user := range s.game.Users() {
+		if s.game.UserSites[user] == site && s.game.IsAlive(user) {
+			// Prepend the attack so they definitely get it next round
+			s.userEventQueue[user] = append([]SiteEvent{attack}, s.userEventQueue[user]...)
+		}
+	}
+}
+
+// returnBeachGoods gives a user back the goods they brought to the beach, if
+// they weren't used to build a raft.
+func (s *SiteVisitController) returnBeachGoods(u User) {
+	goods, ok := s.goBeachResponses[u]
+	if !ok {
+		return
+	}
+	delete(s.goBeachResponses, u)
+	s.game.Inventory(u).Add(goods)
+	s.game.SendInventory(u)
+}
+
+// settleBeach decides whether everyone at the beach escapes together. If
+// they didn't bring enough between them to build a raft, everyone's goods are
+// given back. It only happens once a visit, after the first round.
+func (s *SiteVisitController) settleBeach() {
+	if s.beachSettled {
+		return
+	}
+	s.beachSettled = true
+
+	beachGoers := []User{}
+	for _, u := range s.game.Users() {
+		if s.game.UserSites[u] == Beach && s.game.IsAlive(u) {
+			beachGoers = append(beachGoers, u)
 		}
 	}
+	if len(beachGoers) == 0 {
+		return
+	}
+
+	total := map[CommodityType]int{}
+	for _, goods := range s.goBeachResponses {
+		for commodity, count := range goods {
+			total[commodity] += count
+		}
+	}
+	escaped := true
+	for commodity, count := range s.game.Config.ResourceRequiredToLeave(len(beachGoers)) {
+		if total[commodity] < count {
+			escaped = false
+		}
+	}
+
+	title := "Haha, you are stuck for now. Not enough resources"
+	description := "Now you gotta go back and face the group"
+	if escaped {
+		title = "Together, you have enough resources to leave the island!"
+		description = "LEEEAVE NOW!"
+	}
+	for _, u := range beachGoers {
+		if escaped {
+			// The goods were taken when they were declared, and
+			// are used up building the raft.
+			s.game.Escaped[u] = true
+			delete(s.goBeachResponses, u)
+		} else {
+			s.returnBeachGoods(u)
+		}
+		u.Message(NewEventMessage(title, description))
+		u.Message(NewSetClockMessage(s.game.Config.SiteVisitStatusDuration))
+	}
 }
 
 // RecieveMessage is called when a user sends a message to the server.
 func (s *SiteVisitController) RecieveMessage(u User, m Message) {
 	switch msg := m.(type) {
+	case ResumeMessage:
+		// Replay the clock, and the user's event if they haven't
+		// responded to it yet.
+		u.Message(NewSetClockMessage(s.game.TimeRemaining()))
+		if event, ok := s.ActiveEvent(u); ok {
+			u.Message(event)
+		}
 	case GoBeachMessage:
 		if s.game.UserSites[u] != Beach {
-			panic("GoBeach message received when user not in beach")
+			s.game.reject(u, InvalidStateError, "you aren't at the beach")
+			return
+		}
+		if s.beachSettled {
+			s.game.reject(u, InvalidStateError, "the raft has already been tried")
+			return
+		}
+		// The goods are held until the raft is built, so they can't
+		// be traded away in the meantime.
+		s.returnBeachGoods(u)
+		if !s.game.Inventory(u).Remove(msg.Inventory) {
+			s.game.reject(u, InsufficientResourcesError, "you don't own %v", msg.Inventory)
+			s.game.SendInventory(u)
+			return
 		}
 		s.goBeachResponses[u] = msg.Inventory
+		s.game.SendInventory(u)
 	case EventResponseMessage:
+		if owner, ok := s.eventOwners[msg.MessageID]; !ok || owner != u {
+			s.game.reject(u, NotYourEventError, "event %d wasn't sent to you", msg.MessageID)
+			return
+		}
+
 		// It's possible that the responder has already been called
-		// due to a timer running over. So don't double-handle the event -
-		// just ignore the response.
+		// due to a timer running over, or that the client sent its
+		// response twice. So don't double-handle the event - just
+		// ignore the response.
 		responder, ok := s.messageHandlers[msg.MessageID]
-		if ok {
-			response := responder.End(s.game, u, msg)
-			delete(s.messageHandlers, msg.MessageID)
+		if !ok {
+			log.Printf("User[name=%v] responded to event %d again", u.Name(), msg.MessageID)
+			return
+		}
+		if s.game.GetTime() > s.eventDeadlines[msg.MessageID] {
+			s.game.reject(u, EventExpiredError, "event %d has expired", msg.MessageID)
+			return
+		}
 
-			// Some events have follow-on short status updates. If so,
-			// send the status update to the user immediately.
-			if response != nil {
-				u.Message(response)
+		// If the response is no good, or the user tried to spend
+		// more than they own, leave the event open. The client gets a fresh inventory and may
+		// respond again, otherwise the timer will resolve it.
+		if checker, ok := responder.(responseChecker); ok {
+			if err := checker.CheckResponse(s.game, u, msg); err != nil {
+				s.game.reject(u, MalformedPayloadError, "%v", err)
+				return
 			}
 		}
-		break
-	case DefenseFailedMessage:
-		// The watchtower failed to defend an attack. So it will propagate
-		// to the recipients of the attack.
-		for user, site := range s.game.UserSites {
-			if site == msg.Site {
-				// Prepend the attack so they definitely get it next round
-				s.userEventQueue[user] = append([]SiteEvent{NewAttack()}, s.userEventQueue[user]...)
-			}
+		msg, ok = s.settleResponse(u, s.sentMessages[msg.MessageID], msg)
+		if !ok {
+			s.game.SendInventory(u)
+			return
 		}
+
+		s.resolveEvent(u, msg.MessageID, responder, msg)
+	case DefenseFailedMessage:
+		s.DefenseFailed(msg.Site)
 	default:
 		return
 	}
 }
 
-// NewStateController creates a state controller based on the requested state.
+const (
+	// EscapedOutcome means some players made it off the island.
+	EscapedOutcome string = "escaped"
+	// PerishedOutcome means every player died on the island.
+	PerishedOutcome string = "perished"
+	// StrandedOutcome means the last day passed with players still alive,
+	// but nobody escaped.
+	StrandedOutcome string = "stranded"
+)
+
+// GameOverController is the terminal state. Once it is entered, the game
+// clock is stopped and nothing else happens.
+type GameOverController struct {
+	game    *Game
+	name    GameState
+	message Message
+}
+
+func NewGameOverController(game *Game) *GameOverController {
+	return &GameOverController{
+		game: game,
+		name: GameOverState,
+	}
+}
+
+// Name returns the name of the current state.
+func (s *GameOverController) Name() GameState { return s.name }
+
+// Begin is called when the state becomes active.
+func (s *GameOverController) Begin() {
+	outcome := PerishedOutcome
+	winner := "island"
+	survivors := []string{}
+	for _, u := range s.game.Users() {
+		if s.game.Escaped[u] {
+			survivors = append(survivors, u.Name())
+		} else if s.game.IsAlive(u) {
+			outcome = StrandedOutcome
+		}
+	}
+	if len(survivors) > 0 {
+		outcome = EscapedOutcome
+		winner = "players"
+	}
+
+	log.Printf("Game %q is over: %s, survivors: %v", s.game.name, outcome, survivors)
+	s.message = NewGameOverMessage(winner, outcome, survivors)
+	s.game.connection.Broadcast(s.message)
+	s.game.connection.Stop()
+}
+
+// End is called when the state is no longer active.
+func (s *GameOverController) End() {}
+
+// Timer is called when a timeout occurs.
+func (s *GameOverController) Timer(tick time.Duration) {}
+
+// RecieveMessage is called when a user sends a message to the server.
+func (s *GameOverController) RecieveMessage(u User, m Message) {
+	switch m.(type) {
+	case JoinMessage, ResumeMessage:
+		// Let late arrivals know how it ended.
+		u.Message(s.message)
+	}
+}
+
+// NewStateController creates a state controller based on the requested state,
+// or returns nil if there is no such state.
 func NewStateController(game *Game, state GameState) StateController {
 	switch state {
 	case WaitingState:
@@ -431,7 +786,11 @@ func NewStateController(game *Game, state GameState) StateController {
 		return NewSiteSelectionController(game)
 	case SiteVisitState:
 		return NewSiteVisitController(game)
+	case UpkeepState:
+		return NewUpkeepController(game)
+	case GameOverState:
+		return NewGameOverController(game)
 	default:
-		panic("Unknown state!")
+		return nil
 	}
 }
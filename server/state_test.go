@@ -0,0 +1,491 @@
+package main
+
+import (
+	"testing"
+)
+
+// startVisit moves a game with a single user through waiting and site
+// selection, into a visit to the given site.
+func startVisit(t *testing.T, g *Game, u *fakeUser, site Site) *SiteVisitController {
+	t.Helper()
+	g.RecieveMessage(u, NewReadyMessage(true))
+	if g.state.Name() != SiteSelectionState {
+		t.Fatalf("state = %q after everyone is ready, want %q", g.state.Name(), SiteSelectionState)
+	}
+
+	g.RecieveMessage(u, NewSiteSelectionMessage(site))
+	if g.state.Name() != SiteVisitState {
+		t.Fatalf("state = %q after everyone chose a site, want %q", g.state.Name(), SiteVisitState)
+	}
+	return g.state.(*SiteVisitController)
+}
+
+// nextEvent skips past the current round, and gives the user the given event.
+func nextEvent(t *testing.T, s *SiteVisitController, c *testClock, u *fakeUser, event SiteEvent) EventMessage {
+	t.Helper()
+	s.userEventQueue[u] = []SiteEvent{event}
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+	return u.lastEvent(t)
+}
+
+func TestWaitingRequiresEveryoneReady(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	if g.state.Name() != WaitingState {
+		t.Fatalf("state = %q with one player ready, want %q", g.state.Name(), WaitingState)
+	}
+
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	if g.state.Name() != SiteSelectionState {
+		t.Fatalf("state = %q with everyone ready, want %q", g.state.Name(), SiteSelectionState)
+	}
+}
+
+func TestRepeatedJoinKeepsPlayerState(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	startVisit(t, g, alice, Forest)
+	g.Health[alice] = 1
+	sent := len(alice.messages)
+
+	g.RecieveMessage(alice, NewJoinMessage())
+	if got := g.Health[alice]; got != 1 {
+		t.Errorf("health = %d after joining again, want 1", got)
+	}
+	if got := g.UserSites[alice]; got != Forest {
+		t.Errorf("site = %q after joining again, want %q", got, Forest)
+	}
+	if got := len(g.Users()); got != 1 {
+		t.Errorf("game has %d users after joining again, want 1", got)
+	}
+	if _, ok := findMessage[StateSnapshotMessage](alice.messages[sent:], nil); !ok {
+		t.Errorf("alice wasn't sent a snapshot after joining again")
+	}
+}
+
+func TestSiteVisitStartsWithRepair(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	startVisit(t, g, alice, Forest)
+
+	event := alice.lastEvent(t)
+	if !event.HasSpendButton || event.SpendButtonResource != Log {
+		t.Errorf("first event = %+v, want a repair event spending logs", event)
+	}
+}
+
+func TestRepairSpendsLogs(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	g.Inventory(alice)[Log] = 3
+	startVisit(t, g, alice, Forest)
+
+	event := alice.lastEvent(t)
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, true, false, 2))
+
+	if got, want := g.SiteRepairState[Forest], InitialRepairState+uint64(2*RepairPerLog); got != want {
+		t.Errorf("forest repair = %d, want %d", got, want)
+	}
+	if got := g.Inventory(alice)[Log]; got != 1 {
+		t.Errorf("logs left = %d, want 1", got)
+	}
+}
+
+func TestRepairRejectsUnownedLogs(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	s := startVisit(t, g, alice, Forest)
+
+	event := alice.lastEvent(t)
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, true, false, 5))
+
+	if got := g.SiteRepairState[Forest]; got != InitialRepairState {
+		t.Errorf("forest repair = %d, want %d", got, InitialRepairState)
+	}
+	if _, ok := s.messageHandlers[event.MessageID]; !ok {
+		t.Errorf("rejected response closed the event")
+	}
+}
+
+func TestGetResourceCreditsInventory(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	s := startVisit(t, g, alice, Farm)
+
+	g.SiteRepairState[Farm] = MaxRepairState
+	event := nextEvent(t, s, c, alice, NewGetResource())
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, false, true, 0))
+
+	if got, want := g.Inventory(alice)[Food], 1+RepairYieldBonus; got != want {
+		t.Errorf("food = %d, want %d from a fully repaired farm", got, want)
+	}
+}
+
+func TestAttackDefendedWithBullet(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	g.Inventory(alice)[Bullet] = 1
+	s := startVisit(t, g, alice, Forest)
+
+	event := nextEvent(t, s, c, alice, NewAttack())
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, true, false, 1))
+
+	if got := g.Health[alice]; got != g.Config.MaxHealth {
+		t.Errorf("health = %d, want %d", got, g.Config.MaxHealth)
+	}
+	if got := g.Inventory(alice)[Bullet]; got != 0 {
+		t.Errorf("bullets = %d, want 0", got)
+	}
+}
+
+func TestAttackHurtsWhenIgnored(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	s := startVisit(t, g, alice, Forest)
+
+	nextEvent(t, s, c, alice, NewAttack())
+	c.Advance(SiteVisitRoundDuration)
+
+	if got, want := g.Health[alice], g.Config.MaxHealth-1; got != want {
+		t.Errorf("health = %d, want %d", got, want)
+	}
+}
+
+func TestEveryoneDeadEndsGame(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, conn, c := newTestGame(alice)
+	g.Health[alice] = 1
+	s := startVisit(t, g, alice, Forest)
+
+	nextEvent(t, s, c, alice, NewAttack())
+	c.Advance(SiteVisitRoundDuration)
+	if alice.alive {
+		t.Fatalf("alice survived an attack with 1 health")
+	}
+
+	c.Advance(SiteVisitStatusDuration)
+	if g.state.Name() != GameOverState {
+		t.Fatalf("state = %q, want %q", g.state.Name(), GameOverState)
+	}
+	if !conn.stopped {
+		t.Errorf("game clock was not stopped")
+	}
+	over := conn.broadcasts[len(conn.broadcasts)-1].(GameOverMessage)
+	if over.Outcome != PerishedOutcome {
+		t.Errorf("outcome = %q, want %q", over.Outcome, PerishedOutcome)
+	}
+}
+
+func TestBeachEscape(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, conn, c := newTestGame(alice)
+	g.Inventory(alice)[Log] = 1
+	startVisit(t, g, alice, Beach)
+
+	g.RecieveMessage(alice, NewGoBeachMessage(map[CommodityType]int{Log: 1}))
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+
+	if g.state.Name() != GameOverState {
+		t.Fatalf("state = %q, want %q", g.state.Name(), GameOverState)
+	}
+	over := conn.broadcasts[len(conn.broadcasts)-1].(GameOverMessage)
+	if over.Outcome != EscapedOutcome || len(over.Survivors) != 1 || over.Survivors[0] != "alice" {
+		t.Errorf("game over = %+v, want alice to escape", over)
+	}
+	if got := g.Inventory(alice)[Log]; got != 0 {
+		t.Errorf("logs = %d, want 0 after building the raft", got)
+	}
+}
+
+func TestBeachRejectsUnownedResources(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	startVisit(t, g, alice, Beach)
+
+	g.RecieveMessage(alice, NewGoBeachMessage(map[CommodityType]int{Log: 1}))
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+
+	if g.state.Name() == GameOverState {
+		t.Errorf("escaped with resources alice doesn't own")
+	}
+}
+
+func TestBeachHoldsDeclaredResources(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Log] = 1
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Beach))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Beach))
+
+	g.RecieveMessage(alice, NewGoBeachMessage(map[CommodityType]int{Log: 1}))
+	g.RecieveMessage(alice, NewTradeProposeMessage("bob", map[CommodityType]int{Log: 1}, nil))
+	if len(g.trades) != 0 {
+		t.Errorf("alice offered bob the log they brought to the beach")
+	}
+
+	// Two players need more than one log, so the escape fails and the
+	// log is given back.
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+	if len(g.Escaped) != 0 {
+		t.Fatalf("escaped = %v, want nobody", g.Escaped)
+	}
+	if got := g.Inventory(alice)[Log]; got != 1 {
+		t.Errorf("logs = %d after failing to escape, want 1", got)
+	}
+}
+
+func TestBeachEscapeDoesntDependOnResponseOrder(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice).Add(g.Config.RaftCost)
+	g.Inventory(bob).Add(g.Config.RaftCost)
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Beach))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Beach))
+
+	// Alice is done before bob has said what they brought.
+	g.RecieveMessage(alice, NewGoBeachMessage(g.Config.RaftCost))
+	g.RecieveMessage(alice, NewEventResponseMessage(alice.lastEvent(t).MessageID, true, false, 0))
+	g.RecieveMessage(bob, NewGoBeachMessage(g.Config.RaftCost))
+	c.Advance(SiteVisitRoundDuration)
+
+	if !g.Escaped[alice] || !g.Escaped[bob] {
+		t.Errorf("escaped = %v, want alice and bob to leave together", g.Escaped)
+	}
+}
+
+func TestSiteSelectionAssignsStragglers(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Farm))
+
+	c.Advance(SiteSelectionDuration)
+	if g.state.Name() != SiteVisitState {
+		t.Fatalf("state = %q after site selection timed out, want %q", g.state.Name(), SiteVisitState)
+	}
+	if site := g.UserSites[bob]; site == NoSiteSelected || site == Beach {
+		t.Errorf("bob was sent to %q", site)
+	}
+	if got := g.UserSites[alice]; got != Farm {
+		t.Errorf("alice is at %q, want %q", got, Farm)
+	}
+}
+
+func TestSiteVisitLastsNumSiteVisitRounds(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	s := startVisit(t, g, alice, Forest)
+
+	s.userEventQueue[alice] = nil
+	for i := 0; i < 2*NumSiteVisitRounds; i++ {
+		s.userEventQueue[alice] = append(s.userEventQueue[alice], NewGetResource())
+	}
+	for i := 0; i < NumSiteVisitRounds; i++ {
+		if g.state.Name() != SiteVisitState {
+			t.Fatalf("state = %q after %d rounds, want %q", g.state.Name(), i, SiteVisitState)
+		}
+		c.Advance(SiteVisitRoundDuration)
+		c.Advance(SiteVisitStatusDuration)
+	}
+	if g.state.Name() != UpkeepState {
+		t.Errorf("state = %q after %d rounds, want %q", g.state.Name(), NumSiteVisitRounds, UpkeepState)
+	}
+}
+
+func TestGameEndsAfterLastDay(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, conn, c := newTestGame(alice)
+	g.Config.NumDays = 1
+	s := startVisit(t, g, alice, Forest)
+
+	// The visit carries on with quiet rounds once the events run out.
+	s.userEventQueue[alice] = nil
+	for i := 1; i < NumSiteVisitRounds; i++ {
+		c.Advance(SiteVisitRoundDuration)
+		c.Advance(SiteVisitStatusDuration)
+		if g.state.Name() != SiteVisitState {
+			t.Fatalf("state = %q after %d rounds, want %q", g.state.Name(), i, SiteVisitState)
+		}
+	}
+	if got := alice.lastEvent(t).Title; got != "Nothing happens" {
+		t.Errorf("event = %q with no events left, want a quiet round", got)
+	}
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+
+	if g.state.Name() != GameOverState {
+		t.Fatalf("state = %q after the last day, want %q", g.state.Name(), GameOverState)
+	}
+	over := conn.broadcasts[len(conn.broadcasts)-1].(GameOverMessage)
+	if over.Outcome != StrandedOutcome {
+		t.Errorf("outcome = %q, want %q", over.Outcome, StrandedOutcome)
+	}
+}
+
+func TestMessageInWrongStateIsRejected(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+
+	g.RecieveMessage(alice, NewEventResponseMessage(0, true, false, 0))
+	if got := alice.lastError(); got != InvalidStateError {
+		t.Errorf("error = %q, want %q", got, InvalidStateError)
+	}
+	g.RecieveMessage(alice, NewGoBeachMessage(map[CommodityType]int{}))
+	if got := alice.lastError(); got != InvalidStateError {
+		t.Errorf("error = %q, want %q", got, InvalidStateError)
+	}
+}
+
+func TestGoBeachAwayFromBeachIsRejected(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	startVisit(t, g, alice, Forest)
+
+	g.RecieveMessage(alice, NewGoBeachMessage(map[CommodityType]int{}))
+	if got := alice.lastError(); got != InvalidStateError {
+		t.Errorf("error = %q, want %q", got, InvalidStateError)
+	}
+}
+
+func TestResponseToSomeoneElsesEventIsRejected(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Forest))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Farm))
+	s := g.state.(*SiteVisitController)
+
+	event := alice.lastEvent(t)
+	g.RecieveMessage(bob, NewEventResponseMessage(event.MessageID, true, false, 0))
+	if got := bob.lastError(); got != NotYourEventError {
+		t.Errorf("error = %q, want %q", got, NotYourEventError)
+	}
+	if _, ok := s.messageHandlers[event.MessageID]; !ok {
+		t.Errorf("bob closed alice's event")
+	}
+}
+
+func TestUndefendedObservedAttackReachesSite(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.RecieveMessage(alice, NewReadyMessage(true))
+	g.RecieveMessage(bob, NewReadyMessage(true))
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Watchtower))
+	g.RecieveMessage(bob, NewSiteSelectionMessage(Farm))
+	s := g.state.(*SiteVisitController)
+
+	s.userEventQueue[bob] = nil
+	defense := NewDefense(Farm, []User{alice})
+	s.defenses = append(s.defenses, defense)
+	nextEvent(t, s, c, alice, NewObserveAttack(defense))
+	c.Advance(SiteVisitRoundDuration)
+
+	if len(s.userEventQueue[bob]) == 0 {
+		t.Fatalf("bob has no events after the defense failed")
+	}
+	if _, ok := s.userEventQueue[bob][0].(Attack); !ok {
+		t.Errorf("bob's next event = %T, want an Attack", s.userEventQueue[bob][0])
+	}
+}
+
+func TestUndefendedAttackSurvivesBegin(t *testing.T) {
+	config := DefaultGameConfig()
+	config.NumSiteVisitRounds = 3
+	config.MinEventsPerVisit = 5
+	config.MaxEventsPerVisit = 5
+
+	// Nobody is at the watchtower, so any attack on the forest lands
+	// as the visit begins. Check every game where that happens.
+	attacked := 0
+	for seed := int64(1); seed <= 100; seed++ {
+		alice := newFakeUser("alice")
+		conn := &fakeConnection{users: []*fakeUser{alice}}
+		g := NewGameWithConfig("test", conn, seed, config)
+		g.RecieveMessage(alice, NewJoinMessage())
+		s := startVisit(t, g, alice, Forest)
+
+		onForest := func(m DefenseSummaryMessage) bool { return m.Site == Forest }
+		if _, ok := findMessage(conn.broadcasts, onForest); !ok {
+			continue
+		}
+		attacked++
+		if len(s.userEventQueue[alice]) == 0 {
+			t.Fatalf("seed %d: alice has no events after the attack landed", seed)
+		}
+		if _, ok := s.userEventQueue[alice][0].(Attack); !ok {
+			t.Fatalf("seed %d: alice's next event = %T, want the attack which landed", seed, s.userEventQueue[alice][0])
+		}
+	}
+	if attacked == 0 {
+		t.Fatalf("the forest wasn't attacked in any game")
+	}
+}
+
+func TestRepeatedResponseIsIgnored(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	s := startVisit(t, g, alice, Farm)
+
+	g.SiteRepairState[Farm] = 0
+	event := nextEvent(t, s, c, alice, NewGetResource())
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, false, true, 0))
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, false, true, 0))
+
+	if got := g.Inventory(alice)[Food]; got != 1 {
+		t.Errorf("food = %d, want 1", got)
+	}
+	if got := alice.lastError(); got != "" {
+		t.Errorf("repeated response was rejected with %q", got)
+	}
+}
+
+func TestExpiredResponseIsRejected(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	s := startVisit(t, g, alice, Farm)
+
+	event := nextEvent(t, s, c, alice, NewGetResource())
+	s.userEventQueue[alice] = []SiteEvent{NewGetResource()}
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+	g.RecieveMessage(alice, NewEventResponseMessage(event.MessageID, false, true, 0))
+
+	if got := alice.lastError(); got != EventExpiredError {
+		t.Errorf("error = %q, want %q", got, EventExpiredError)
+	}
+	if got := g.Inventory(alice)[Food]; got != 0 {
+		t.Errorf("food = %d, want 0", got)
+	}
+}
+
+func TestEventIDsAreUniqueAcrossVisits(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	first := startVisit(t, g, alice, Farm)
+	id := alice.lastEvent(t).MessageID
+
+	first.userEventQueue[alice] = nil
+	c.Advance(SiteVisitRoundDuration)
+	c.Advance(SiteVisitStatusDuration)
+	c.Advance(UpkeepDuration)
+	g.RecieveMessage(alice, NewSiteSelectionMessage(Farm))
+	if g.state.Name() != SiteVisitState {
+		t.Fatalf("state = %q, want a second visit", g.state.Name())
+	}
+
+	if got := alice.lastEvent(t).MessageID; got <= id {
+		t.Errorf("second visit's first event has ID %d, after %d", got, id)
+	}
+}
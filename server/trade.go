@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// TradeOfferTimeout is how long a trade offer stays open before the
+	// escrowed goods are returned to the proposer.
+	TradeOfferTimeout time.Duration = 30 * time.Second
+)
+
+// Reasons given when a trade is closed.
+const (
+	TradeAccepted  string = "accepted"
+	TradeDeclined  string = "declined"
+	TradeCountered string = "countered"
+	TradeExpired   string = "expired"
+	TradeCancelled string = "cancelled"
+	TradeInvalid   string = "invalid"
+)
+
+// A Trade is an offer from one user to another. The goods offered are taken
+// out of the proposer's inventory and held in escrow until the trade is
+// accepted, declined or expires.
+type Trade struct {
+	ID      uint64
+	From    User
+	To      User
+	Offer   map[CommodityType]int
+	Request map[CommodityType]int
+	Expires time.Duration
+}
+
+// validateGoods checks that a trade payload only mentions known commodities
+// in non-negative amounts.
+func validateGoods(goods map[CommodityType]int) error {
+	for c, n := range goods {
+		known := false
+		for _, k := range AllCommodities {
+			if c == k {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown commodity %q", c)
+		}
+		if n < 0 {
+			return fmt.Errorf("negative amount of %q", c)
+		}
+	}
+	return nil
+}
+
+// decodeGoods parses goods the way the client sends them in a bump trade.
+func decodeGoods(materials string) (map[CommodityType]int, error) {
+	goods := map[CommodityType]int{}
+	if err := json.Unmarshal([]byte(materials), &goods); err != nil {
+		return nil, err
+	}
+	if err := validateGoods(goods); err != nil {
+		return nil, err
+	}
+	return goods, nil
+}
+
+// encodeGoods serializes goods the way the client sends them in a trade,
+// with every commodity present.
+func encodeGoods(goods map[CommodityType]int) string {
+	full := NewInventory()
+	full.Add(goods)
+	data, _ := json.Marshal(full)
+	return string(data)
+}
+
+// FindUser returns the user in this game with the given name.
+func (g *Game) FindUser(name string) (User, error) {
+	var found User
+	for u, _ := range g.UserSites {
+		if u.Name() != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("more than one player is called %q", name)
+		}
+		found = u
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no player called %q", name)
+	}
+	return found, nil
+}
+
+// rejectTrade tells a user that their trade request couldn't be processed.
+func (g *Game) rejectTrade(u User, id uint64, format string, args ...interface{}) {
+	detail := fmt.Sprintf(format, args...)
+	log.Printf("Trade %d from %q rejected: %s", id, u.Name(), detail)
+	u.Message(NewTradeClosedMessage(id, TradeInvalid, detail))
+}
+
+// ProposeTrade opens a trade from one user to another, escrowing the offered
+// goods.
+func (g *Game) ProposeTrade(from User, toName string, offer, request map[CommodityType]int) {
+	to, err := g.FindUser(toName)
+	if err != nil {
+		g.rejectTrade(from, 0, "%v", err)
+		return
+	}
+	g.openTrade(from, to, offer, request)
+}
+
+func (g *Game) openTrade(from, to User, offer, request map[CommodityType]int) {
+	if to == from {
+		g.rejectTrade(from, 0, "can't trade with yourself")
+		return
+	}
+	if !g.IsAlive(from) || !g.IsAlive(to) {
+		g.rejectTrade(from, 0, "dead players can't trade")
+		return
+	}
+	if err := validateGoods(offer); err != nil {
+		g.rejectTrade(from, 0, "invalid offer: %v", err)
+		return
+	}
+	if err := validateGoods(request); err != nil {
+		g.rejectTrade(from, 0, "invalid request: %v", err)
+		return
+	}
+	if !g.Inventory(from).Remove(offer) {
+		g.rejectTrade(from, 0, "you don't own %v", offer)
+		g.SendInventory(from)
+		return
+	}
+	g.SendInventory(from)
+
+	g.nextTradeID += 1
+	t := &Trade{
+		ID:      g.nextTradeID,
+		From:    from,
+		To:      to,
+		Offer:   offer,
+		Request: request,
+		Expires: g.GetTime() + g.Config.TradeOfferTimeout,
+	}
+	g.trades[t.ID] = t
+
+	log.Printf("Trade %d proposed from %q to %q: %v for %v", t.ID, from.Name(), to.Name(), offer, request)
+	msg := NewTradeOfferedMessage(t, g.Config.TradeOfferTimeout)
+	from.Message(msg)
+	to.Message(msg)
+}
+
+// CounterTrade replaces an offer with new terms from its recipient. The
+// original offer is closed and a new one is opened in the other direction.
+func (g *Game) CounterTrade(u User, id uint64, offer, request map[CommodityType]int) {
+	t, ok := g.trades[id]
+	if !ok || t.To != u {
+		g.rejectTrade(u, id, "no such trade offered to you")
+		return
+	}
+	g.closeTrade(t, TradeCountered)
+	g.openTrade(t.To, t.From, offer, request)
+}
+
+// AcceptTrade completes a trade, as long as the recipient owns what was
+// requested of them. If the proposer has died since, the trade is cancelled.
+func (g *Game) AcceptTrade(u User, id uint64) {
+	t, ok := g.trades[id]
+	if !ok || t.To != u {
+		g.rejectTrade(u, id, "no such trade offered to you")
+		return
+	}
+	if !g.IsAlive(t.From) {
+		g.closeTrade(t, TradeCancelled)
+		return
+	}
+	if !g.Inventory(t.To).Remove(t.Request) {
+		g.rejectTrade(u, id, "you don't own %v", t.Request)
+		g.SendInventory(u)
+		return
+	}
+
+	delete(g.trades, id)
+	g.Inventory(t.From).Add(t.Request)
+	g.Inventory(t.To).Add(t.Offer)
+
+	log.Printf("Trade %d accepted", id)
+	for _, party := range []User{t.From, t.To} {
+		party.Message(NewTradeClosedMessage(id, TradeAccepted, ""))
+		g.SendInventory(party)
+	}
+	t.From.Message(NewTradeCompletedMessage(encodeGoods(t.Request)))
+	t.To.Message(NewTradeCompletedMessage(encodeGoods(t.Offer)))
+}
+
+// DeclineTrade closes a trade. Either party may decline: for the proposer
+// this withdraws the offer.
+func (g *Game) DeclineTrade(u User, id uint64) {
+	t, ok := g.trades[id]
+	if !ok || (t.To != u && t.From != u) {
+		g.rejectTrade(u, id, "no such trade")
+		return
+	}
+	g.closeTrade(t, TradeDeclined)
+}
+
+// closeTrade returns the escrowed goods to the proposer and informs both
+// parties.
+func (g *Game) closeTrade(t *Trade, reason string) {
+	log.Printf("Trade %d closed: %s", t.ID, reason)
+	delete(g.trades, t.ID)
+	g.Inventory(t.From).Add(t.Offer)
+	g.SendInventory(t.From)
+
+	msg := NewTradeClosedMessage(t.ID, reason, "")
+	t.From.Message(msg)
+	t.To.Message(msg)
+}
+
+// openTrades returns all open trades, oldest first.
+func (g *Game) openTrades() []*Trade {
+	trades := []*Trade{}
+	for _, t := range g.trades {
+		trades = append(trades, t)
+	}
+	sort.Slice(trades, func(i, j int) bool { return trades[i].ID < trades[j].ID })
+	return trades
+}
+
+// cancelTradesFor closes every trade a user is involved in, e.g. because
+// they left the game.
+func (g *Game) cancelTradesFor(u User) {
+	for _, t := range g.openTrades() {
+		if t.From == u || t.To == u {
+			g.closeTrade(t, TradeCancelled)
+		}
+	}
+}
+
+// expireTrades closes every trade which has been open for too long.
+func (g *Game) expireTrades() {
+	for _, t := range g.openTrades() {
+		if g.GetTime() >= t.Expires {
+			g.closeTrade(t, TradeExpired)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"testing"
+)
+
+// offerLogsForFood has alice offer bob two logs for a food, and returns the
+// trade.
+func offerLogsForFood(t *testing.T, g *Game, alice, bob *fakeUser) TradeOfferedMessage {
+	t.Helper()
+	g.Inventory(alice)[Log] = 2
+	g.RecieveMessage(alice, NewTradeProposeMessage("bob", map[CommodityType]int{Log: 2}, map[CommodityType]int{Food: 1}))
+	return lastMessage[TradeOfferedMessage](t, bob.messages, nil)
+}
+
+// closedReason returns why the most recent trade closed for the user.
+func closedReason(t *testing.T, u *fakeUser) string {
+	t.Helper()
+	return lastMessage[TradeClosedMessage](t, u.messages, nil).Reason
+}
+
+func TestProposeTradeEscrowsOffer(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+
+	offer := offerLogsForFood(t, g, alice, bob)
+	if offer.From != "alice" || offer.To != "bob" || offer.Offer[Log] != 2 || offer.Request[Food] != 1 {
+		t.Errorf("offer = %+v, want two logs from alice for a food from bob", offer)
+	}
+	if got := g.Inventory(alice)[Log]; got != 0 {
+		t.Errorf("alice has %d logs while they're offered, want 0", got)
+	}
+}
+
+func TestProposeTradeRequiresOfferedGoods(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+
+	g.RecieveMessage(alice, NewTradeProposeMessage("bob", map[CommodityType]int{Log: 1}, nil))
+	if got := closedReason(t, alice); got != TradeInvalid {
+		t.Errorf("reason = %q, want %q", got, TradeInvalid)
+	}
+	if len(g.trades) != 0 {
+		t.Errorf("trades = %v, want none", g.trades)
+	}
+}
+
+func TestAcceptTradeSwapsGoods(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offer := offerLogsForFood(t, g, alice, bob)
+	g.Inventory(bob)[Food] = 1
+
+	g.RecieveMessage(bob, NewTradeAcceptMessage(offer.TradeID))
+	if got := closedReason(t, alice); got != TradeAccepted {
+		t.Errorf("reason = %q, want %q", got, TradeAccepted)
+	}
+	if g.Inventory(alice)[Food] != 1 || g.Inventory(bob)[Log] != 2 || g.Inventory(bob)[Food] != 0 {
+		t.Errorf("inventories = %v and %v, want the goods swapped", g.Inventory(alice), g.Inventory(bob))
+	}
+}
+
+func TestAcceptTradeRequiresRequestedGoods(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offer := offerLogsForFood(t, g, alice, bob)
+
+	g.RecieveMessage(bob, NewTradeAcceptMessage(offer.TradeID))
+	if got := closedReason(t, bob); got != TradeInvalid {
+		t.Errorf("reason = %q, want %q", got, TradeInvalid)
+	}
+	if _, ok := g.trades[offer.TradeID]; !ok {
+		t.Errorf("trade was closed, want it to stay open")
+	}
+}
+
+func TestOnlyRecipientCanAcceptTrade(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offer := offerLogsForFood(t, g, alice, bob)
+
+	g.RecieveMessage(alice, NewTradeAcceptMessage(offer.TradeID))
+	if _, ok := g.trades[offer.TradeID]; !ok {
+		t.Errorf("alice accepted their own trade")
+	}
+}
+
+func TestDeclineTradeReturnsEscrow(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offer := offerLogsForFood(t, g, alice, bob)
+
+	g.RecieveMessage(bob, NewTradeDeclineMessage(offer.TradeID))
+	if got := closedReason(t, alice); got != TradeDeclined {
+		t.Errorf("reason = %q, want %q", got, TradeDeclined)
+	}
+	if got := g.Inventory(alice)[Log]; got != 2 {
+		t.Errorf("alice has %d logs after the trade was declined, want 2", got)
+	}
+}
+
+func TestCounterTradeReversesOffer(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offer := offerLogsForFood(t, g, alice, bob)
+	g.Inventory(bob)[Food] = 1
+
+	g.RecieveMessage(bob, NewTradeCounterMessage(offer.TradeID, map[CommodityType]int{Food: 1}, map[CommodityType]int{Log: 1}))
+	counter := lastMessage[TradeOfferedMessage](t, alice.messages, nil)
+	if counter.TradeID == offer.TradeID || counter.From != "bob" || counter.Request[Log] != 1 {
+		t.Errorf("counter = %+v, want a new offer from bob for one log", counter)
+	}
+	if g.Inventory(alice)[Log] != 2 || g.Inventory(bob)[Food] != 0 {
+		t.Errorf("inventories = %v and %v, want alice's escrow returned and bob's taken", g.Inventory(alice), g.Inventory(bob))
+	}
+}
+
+func TestTradeExpires(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	offerLogsForFood(t, g, alice, bob)
+
+	c.Advance(g.Config.TradeOfferTimeout)
+	if got := closedReason(t, bob); got != TradeExpired {
+		t.Errorf("reason = %q, want %q", got, TradeExpired)
+	}
+	if got := g.Inventory(alice)[Log]; got != 2 {
+		t.Errorf("alice has %d logs after the trade expired, want 2", got)
+	}
+}
+
+func TestLeavingCancelsTrades(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offerLogsForFood(t, g, alice, bob)
+
+	g.RecieveMessage(bob, NewLeaveMessage())
+	if got := closedReason(t, alice); got != TradeCancelled {
+		t.Errorf("reason = %q, want %q", got, TradeCancelled)
+	}
+	if got := g.Inventory(alice)[Log]; got != 2 {
+		t.Errorf("alice has %d logs after bob left, want 2", got)
+	}
+}
+
+func TestBumpTradeWithUnownedGoodsIsRejected(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(bob)[Food] = 1
+
+	g.RecieveMessage(alice, NewTradeMessage(`{"log":1}`, ""))
+	g.RecieveMessage(bob, NewTradeMessage(`{"food":1}`, ""))
+	c.Advance(g.Config.TradeTimeout)
+
+	if got := alice.lastError(); got != InsufficientResourcesError {
+		t.Errorf("alice's error = %q, want %q", got, InsufficientResourcesError)
+	}
+	if got := closedReason(t, bob); got != TradeInvalid {
+		t.Errorf("bob's reason = %q, want %q", got, TradeInvalid)
+	}
+	if got := g.Inventory(bob)[Food]; got != 1 {
+		t.Errorf("bob has %d food, want 1", got)
+	}
+}
+
+func TestBumpTradeWithMalformedGoodsIsRejected(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+
+	g.RecieveMessage(alice, NewTradeMessage(`{"gold":0}`, ""))
+	g.RecieveMessage(bob, NewTradeMessage(`{}`, ""))
+	c.Advance(g.Config.TradeTimeout)
+
+	if got := alice.lastError(); got != MalformedPayloadError {
+		t.Errorf("alice's error = %q, want %q", got, MalformedPayloadError)
+	}
+	if _, ok := g.Inventory(bob)["gold"]; ok {
+		t.Errorf("bob was given an unknown commodity")
+	}
+}
+
+func TestPlayersWithDefaultNameCanTrade(t *testing.T) {
+	first, second := newFakeUser("Anonymous"), newFakeUser("Anonymous")
+	g, _, _ := newTestGame(first, second)
+	if second.Name() != "Anonymous 2" {
+		t.Fatalf("second player is called %q, want %q", second.Name(), "Anonymous 2")
+	}
+
+	g.Inventory(first)[Log] = 1
+	g.RecieveMessage(first, NewTradeProposeMessage(second.Name(), map[CommodityType]int{Log: 1}, nil))
+	if _, ok := findMessage[TradeOfferedMessage](second.messages, nil); !ok {
+		t.Errorf("players who kept the default name couldn't trade")
+	}
+}
+
+func TestSetNameRejectsTakenName(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+
+	g.RecieveMessage(bob, NewSetNameMessage("alice"))
+	if got := bob.lastError(); got != NameTakenError {
+		t.Errorf("error = %q, want %q", got, NameTakenError)
+	}
+	if bob.Name() != "bob" {
+		t.Errorf("bob renamed themselves to %q", bob.Name())
+	}
+}
+
+func TestAcceptTradeFromDeadPlayerIsCancelled(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	offer := offerLogsForFood(t, g, alice, bob)
+	g.Inventory(bob)[Food] = 1
+	g.kill(alice)
+
+	g.RecieveMessage(bob, NewTradeAcceptMessage(offer.TradeID))
+	if got := closedReason(t, bob); got != TradeCancelled {
+		t.Errorf("reason = %q, want %q", got, TradeCancelled)
+	}
+	if g.Inventory(bob)[Log] != 0 || g.Inventory(bob)[Food] != 1 {
+		t.Errorf("bob's inventory = %v, want nothing traded with a dead player", g.Inventory(bob))
+	}
+}
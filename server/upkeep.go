@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// The defaults for the upkeep rules in GameConfig.
+const (
+	// FoodPerDay is how much food each player has to eat at the end of
+	// every day.
+	FoodPerDay int = 1
+
+	// StarvationDamage is how much health a player loses for going hungry.
+	StarvationDamage int = 1
+
+	// UpkeepDuration is how long players have to share their food before
+	// everyone eats.
+	UpkeepDuration time.Duration = 15 * time.Second
+)
+
+// UpkeepController runs the end of each day. Everyone alive has to eat, and
+// until the clock runs out they can share food with anyone who doesn't have
+// enough. Then everyone eats what they can, anyone who went hungry starves a
+// little, and anyone bleeding bleeds.
+type UpkeepController struct {
+	game *Game
+	name GameState
+}
+
+func NewUpkeepController(game *Game) *UpkeepController {
+	return &UpkeepController{
+		game: game,
+		name: UpkeepState,
+	}
+}
+
+// Name returns the name of the current state.
+func (s *UpkeepController) Name() GameState { return s.name }
+
+// Begin is called when the state becomes active. If nobody is short of food
+// there's nothing to share, so everyone eats at the next tick.
+func (s *UpkeepController) Begin() {
+	if s.everyoneFed() {
+		s.game.SetTimeout(TickInterval)
+		return
+	}
+	s.game.SetTimeout(s.game.Config.UpkeepDuration)
+	s.game.connection.Broadcast(NewSetClockMessage(s.game.Config.UpkeepDuration))
+	s.game.connection.Broadcast(s.status())
+}
+
+// End is called when the state is no longer active.
+func (s *UpkeepController) End() {}
+
+// Timer is called when a timeout occurs.
+func (s *UpkeepController) Timer(tick time.Duration) {
+	s.eat()
+	s.game.Bleed()
+	if s.game.IsOver() {
+		s.game.ChangeState(GameOverState)
+		return
+	}
+	s.game.ChangeState(SiteSelectionState)
+}
+
+// eat takes each player's food for the day from their inventory, and hurts
+// anyone who didn't have enough.
+func (s *UpkeepController) eat() {
+	need := s.game.Config.FoodPerDay
+	for _, u := range s.game.Users() {
+		if !s.game.IsAlive(u) {
+			continue
+		}
+
+		inv := s.game.Inventory(u)
+		eaten := need
+		if inv[Food] < eaten {
+			eaten = inv[Food]
+		}
+		if eaten > 0 {
+			inv.Remove(map[CommodityType]int{Food: eaten})
+			s.game.SendInventory(u)
+		}
+		if eaten < need {
+			log.Printf("User[name=%v] went hungry", u.Name())
+			s.game.ModifyHealth(u, -s.game.Config.StarvationDamage)
+		}
+	}
+}
+
+// short returns how much more food the user needs to eat today.
+func (s *UpkeepController) short(u User) int {
+	short := s.game.Config.FoodPerDay - s.game.Inventory(u)[Food]
+	if short < 0 {
+		return 0
+	}
+	return short
+}
+
+// everyoneFed returns true if nobody alive is short of food.
+func (s *UpkeepController) everyoneFed() bool {
+	for _, u := range s.game.Users() {
+		if s.game.IsAlive(u) && s.short(u) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// status describes how much food everyone alive has, and needs.
+func (s *UpkeepController) status() Message {
+	var info []UpkeepInfo
+	for _, u := range s.game.Users() {
+		if !s.game.IsAlive(u) {
+			continue
+		}
+		info = append(info, UpkeepInfo{
+			Name:  u.Name(),
+			Food:  s.game.Inventory(u)[Food],
+			Short: s.short(u),
+		})
+	}
+	return NewUpkeepMessage(s.game.Config.FoodPerDay, info)
+}
+
+// shareFood moves food from one player to another.
+func (s *UpkeepController) shareFood(from User, msg ShareFoodMessage) {
+	to, err := s.game.FindUser(msg.To)
+	if err != nil {
+		s.game.reject(from, MalformedPayloadError, "%v", err)
+		return
+	}
+	if to == from || !s.game.IsAlive(to) {
+		s.game.reject(from, MalformedPayloadError, "can't share food with %q", msg.To)
+		return
+	}
+	if msg.Amount <= 0 {
+		s.game.reject(from, MalformedPayloadError, "can't share %d food", msg.Amount)
+		return
+	}
+
+	food := map[CommodityType]int{Food: msg.Amount}
+	if !s.game.Inventory(from).Remove(food) {
+		s.game.reject(from, InsufficientResourcesError, "you don't have %d food", msg.Amount)
+		s.game.SendInventory(from)
+		return
+	}
+	s.game.Inventory(to).Add(food)
+	s.game.SendInventory(from)
+	s.game.SendInventory(to)
+	s.game.connection.Broadcast(s.status())
+}
+
+// RecieveMessage is called when a user sends a message to the server.
+func (s *UpkeepController) RecieveMessage(u User, m Message) {
+	switch msg := m.(type) {
+	case ResumeMessage:
+		u.Message(NewSetClockMessage(s.game.TimeRemaining()))
+		u.Message(s.status())
+	case ShareFoodMessage:
+		s.shareFood(u, msg)
+	}
+}
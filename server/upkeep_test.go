@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+// startUpkeep moves the game straight to upkeep.
+func startUpkeep(t *testing.T, g *Game) {
+	t.Helper()
+	g.ChangeState(UpkeepState)
+	if g.state.Name() != UpkeepState {
+		t.Fatalf("state = %q, want %q", g.state.Name(), UpkeepState)
+	}
+}
+
+func TestUpkeepEatsFood(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Food] = 2
+	startUpkeep(t, g)
+
+	c.Advance(UpkeepDuration)
+	if g.state.Name() != SiteSelectionState {
+		t.Fatalf("state = %q after upkeep, want %q", g.state.Name(), SiteSelectionState)
+	}
+	if got := g.Inventory(alice)[Food]; got != 2-FoodPerDay {
+		t.Errorf("alice's food = %d, want %d", got, 2-FoodPerDay)
+	}
+	if got := g.Health[alice]; got != g.Config.MaxHealth {
+		t.Errorf("alice's health = %d, want %d", got, g.Config.MaxHealth)
+	}
+	if got, want := g.Health[bob], g.Config.MaxHealth-StarvationDamage; got != want {
+		t.Errorf("bob's health = %d after going hungry, want %d", got, want)
+	}
+}
+
+func TestSharedFoodFeedsOthers(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, conn, c := newTestGame(alice, bob)
+	g.Inventory(alice)[Food] = 2
+	startUpkeep(t, g)
+
+	g.RecieveMessage(alice, NewShareFoodMessage("bob", 1))
+	status := conn.broadcasts[len(conn.broadcasts)-1].(UpkeepMessage)
+	if len(status.Players) != 2 || status.Players[1].Short != 0 {
+		t.Errorf("upkeep status = %+v, want bob fed", status)
+	}
+
+	c.Advance(UpkeepDuration)
+	for _, u := range []User{alice, bob} {
+		if got := g.Health[u]; got != g.Config.MaxHealth {
+			t.Errorf("%s's health = %d, want %d", u.Name(), got, g.Config.MaxHealth)
+		}
+	}
+}
+
+func TestShareFoodRejectsUnownedFood(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	g.Inventory(alice)[Food] = 1
+	startUpkeep(t, g)
+
+	g.RecieveMessage(alice, NewShareFoodMessage("bob", 2))
+	if got := alice.lastError(); got != InsufficientResourcesError {
+		t.Errorf("error = %q, want %q", got, InsufficientResourcesError)
+	}
+	if got := g.Inventory(bob)[Food]; got != 0 {
+		t.Errorf("bob's food = %d, want 0", got)
+	}
+}
+
+func TestUpkeepIsQuickWhenEveryoneCanEat(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, c := newTestGame(alice)
+	g.Inventory(alice)[Food] = 1
+	startUpkeep(t, g)
+
+	c.Advance(TickInterval)
+	if g.state.Name() != SiteSelectionState {
+		t.Errorf("state = %q with nobody hungry, want %q", g.state.Name(), SiteSelectionState)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	// RepairYieldBonus is how many extra resources a fully repaired site
+	// yields, for each one a broken down site yields.
+	RepairYieldBonus int = 1
+
+	// DepletionPerFind is how much of a site's resources each find uses
+	// up. The site yields less until it has regenerated.
+	DepletionPerFind float64 = 0.15
+
+	// MaxDepletion keeps a little something at every site, however much
+	// it has been picked over.
+	MaxDepletion float64 = 0.8
+
+	// DailyRegeneration is how much of its resources each site recovers
+	// at the end of every day.
+	DailyRegeneration float64 = 0.3
+)
+
+// SiteResources is the commodity found at each site.
+var SiteResources = map[Site]CommodityType{
+	Forest:     Log,
+	Farm:       Food,
+	Hospital:   Bandage,
+	Watchtower: Bullet,
+}
+
+// SiteYield returns how much of its commodity a user would find at their
+// site right now. It starts from the commodity's Yield, which is scaled up
+// the better repaired the site is, and down the more players are there to
+// share it and the more depleted it is.
+func (g *Game) SiteYield(u User) float64 {
+	site := g.UserSites[u]
+	resource, ok := SiteResources[site]
+	if !ok {
+		return 0
+	}
+
+	repair := float64(g.SiteRepairState[site]) / float64(MaxRepairState)
+	crowd := 0
+	for _, other := range g.Users() {
+		if g.UserSites[other] == site && g.IsAlive(other) {
+			crowd++
+		}
+	}
+	if crowd < 1 {
+		crowd = 1
+	}
+
+	yield := g.Yield[resource]
+	yield *= 1 + float64(RepairYieldBonus)*repair
+	yield *= 2 / float64(crowd+1)
+	yield *= 1 - g.Depletion[site]
+	return yield
+}
+
+// Harvest works out how much a user finds at their site, and depletes the
+// site. Only whole resources can be found, so the fraction left over is
+// kept for the user's next find of the same commodity.
+func (g *Game) Harvest(u User) (CommodityType, int) {
+	site := g.UserSites[u]
+	resource, ok := SiteResources[site]
+	if !ok {
+		return "", 0
+	}
+
+	carry, ok := g.yieldCarry[u]
+	if !ok {
+		carry = map[CommodityType]float64{}
+		g.yieldCarry[u] = carry
+	}
+	total := carry[resource] + g.SiteYield(u)
+	amount := math.Floor(total)
+	carry[resource] = total - amount
+
+	g.Depletion[site] = math.Min(g.Depletion[site]+DepletionPerFind, MaxDepletion)
+	return resource, int(amount)
+}
+
+// RegenerateSites lets every site recover some of its resources at the end
+// of a day.
+func (g *Game) RegenerateSites() {
+	for _, site := range AllSites() {
+		g.Depletion[site] = math.Max(g.Depletion[site]-DailyRegeneration, 0)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCrowdedSitesYieldLess(t *testing.T) {
+	alice, bob := newFakeUser("alice"), newFakeUser("bob")
+	g, _, _ := newTestGame(alice, bob)
+	g.UserSites[alice] = Forest
+	g.UserSites[bob] = Farm
+
+	alone := g.SiteYield(alice)
+	g.UserSites[bob] = Forest
+	if crowded := g.SiteYield(alice); crowded >= alone {
+		t.Errorf("yield with company = %v, want less than %v alone", crowded, alone)
+	}
+}
+
+func TestHarvestDepletesSite(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	g.UserSites[alice] = Forest
+
+	fresh := g.SiteYield(alice)
+	for i := 0; i < 10; i++ {
+		g.Harvest(alice)
+	}
+	if got := g.Depletion[Forest]; got != MaxDepletion {
+		t.Errorf("forest depletion = %v, want %v", got, MaxDepletion)
+	}
+	if depleted := g.SiteYield(alice); depleted >= fresh {
+		t.Errorf("depleted yield = %v, want less than %v", depleted, fresh)
+	}
+
+	g.RegenerateSites()
+	if got, want := g.Depletion[Forest], MaxDepletion-DailyRegeneration; got != want {
+		t.Errorf("forest depletion = %v after a day, want %v", got, want)
+	}
+}
+
+func TestHarvestCarriesFractions(t *testing.T) {
+	alice := newFakeUser("alice")
+	g, _, _ := newTestGame(alice)
+	g.UserSites[alice] = Farm
+	g.SiteRepairState[Farm] = 0
+	g.Yield[Food] = 0.5
+
+	var found []int
+	for i := 0; i < 2; i++ {
+		g.Depletion[Farm] = 0
+		_, n := g.Harvest(alice)
+		found = append(found, n)
+	}
+	if found[0] != 0 || found[1] != 1 {
+		t.Errorf("found %v food, want nothing then the whole of it", found)
+	}
+}